@@ -0,0 +1,50 @@
+// Package auth authenticates the requests jirate sends to Jira.
+// A profile picks one Method: basic auth with an API token, a bearer Personal Access Token on Jira Data Center,
+// or an OAuth 2.0 (3LO) app on Jira Cloud.
+package auth
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/andygrunwald/go-jira"
+)
+
+// Method authenticates requests to a Jira site
+type Method interface {
+	// Client returns an HTTP client that authenticates every request it sends.
+	// The context bounds what setting up the client takes, such as logging in.
+	Client(ctx context.Context) (*http.Client, error)
+}
+
+// Endpoint is implemented by methods that reach the site through another URL than its own,
+// such as OAuth apps, which call Jira Cloud through api.atlassian.com
+type Endpoint interface {
+	BaseURL(ctx context.Context) (string, error)
+}
+
+// Basic authenticates with a username and an API token or password
+type Basic struct {
+	Username string
+	Password string
+}
+
+func (b *Basic) Client(context.Context) (*http.Client, error) {
+	transport := jira.BasicAuthTransport{
+		Username: b.Username,
+		Password: b.Password,
+	}
+	return transport.Client(), nil
+}
+
+// Bearer authenticates with a token in the Authorization header, such as a Jira Data Center Personal Access Token
+type Bearer struct {
+	Token string
+}
+
+func (b *Bearer) Client(context.Context) (*http.Client, error) {
+	transport := jira.BearerAuthTransport{
+		Token: b.Token,
+	}
+	return transport.Client(), nil
+}
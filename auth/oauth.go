@@ -0,0 +1,375 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Atlassian's OAuth 2.0 (3LO) endpoints
+const (
+	AtlassianAuthURL      = "https://auth.atlassian.com/authorize"
+	AtlassianTokenURL     = "https://auth.atlassian.com/oauth/token"
+	AtlassianResourcesURL = "https://api.atlassian.com/oauth/token/accessible-resources"
+	AtlassianAPIURL       = "https://api.atlassian.com/ex/jira/"
+)
+
+const (
+	DefaultCallbackPort = 8910            // The callback URL of the OAuth app must be http://localhost:8910/callback
+	refreshMargin       = time.Minute     // Tokens are refreshed this long before they expire
+	loginTimeout        = 5 * time.Minute // How long to wait for the user to grant access in the browser
+)
+
+// DefaultScopes are the scopes requested when the profile lists none.
+// offline_access is what makes Atlassian hand out refresh tokens.
+var DefaultScopes = []string{"read:jira-work", "write:jira-work", "read:jira-user", "offline_access"}
+
+// OAuth authenticates as the user who granted an OAuth 2.0 (3LO) app access to a Jira Cloud site.
+// The token is kept in the Store and refreshed shortly before it expires. Login sends the user through the
+// authorization code flow in the browser, which redirects back to a server on the loopback interface.
+type OAuth struct {
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	CallbackPort int    // Port of the loopback callback server, DefaultCallbackPort when 0
+	Site         string // URL of the Jira Cloud site, picks the site among those the user granted access to
+	Store        TokenStore
+
+	// Endpoints, Atlassian's when empty, e.g. to point them at a stand-in server
+	AuthURL      string
+	TokenURL     string
+	ResourcesURL string
+	APIURL       string
+
+	Open       func(url string) error // Shows the authorization page to the user, OpenBrowser when nil
+	HTTPClient *http.Client           // Sends token requests, http.DefaultClient when nil
+
+	mu    sync.Mutex
+	token *Token
+}
+
+// Client returns an HTTP client that sends a fresh access token with every request
+func (o *OAuth) Client(ctx context.Context) (*http.Client, error) {
+	if _, err := o.Token(ctx); err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: &oauthTransport{oauth: o}}, nil
+}
+
+// BaseURL is the URL apps reach the REST API of the site at
+func (o *OAuth) BaseURL(ctx context.Context) (string, error) {
+	token, err := o.Token(ctx)
+	if err != nil {
+		return "", err
+	}
+	return orDefault(o.APIURL, AtlassianAPIURL) + token.CloudID, nil
+}
+
+// ErrLoginRequired is returned when there is no OAuth token, or it expired and cannot be refreshed.
+// Only jirate auth login sends the user through the browser, requests never do.
+var ErrLoginRequired = errors.New("The OAuth token is missing or expired, run `jirate auth login`")
+
+// Token returns a valid access token, refreshing it when needed. It returns ErrLoginRequired when the user has
+// to log in again.
+func (o *OAuth) Token(ctx context.Context) (*Token, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.token == nil {
+		token, err := o.Store.Load()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read the stored OAuth token: %v", err)
+		}
+		if token == nil {
+			return nil, ErrLoginRequired
+		}
+		o.token = token
+	}
+	if o.token.expiresWithin(refreshMargin) {
+		if o.token.RefreshToken == "" {
+			return nil, ErrLoginRequired
+		}
+		if err := o.refresh(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: failed to refresh it: %v", ErrLoginRequired, err)
+		}
+	}
+	return o.token, nil
+}
+
+// Login sends the user through the authorization flow even when a token is stored, and stores the new token
+func (o *OAuth) Login(ctx context.Context) (*Token, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.login(ctx)
+}
+
+type callbackResult struct {
+	code string
+	err  error
+}
+
+func (o *OAuth) login(ctx context.Context) (*Token, error) {
+	port := o.CallbackPort
+	if port == 0 {
+		port = DefaultCallbackPort
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to start the OAuth callback server: %v", err)
+	}
+	redirectURI := fmt.Sprintf("http://localhost:%d/callback", port)
+
+	state, err := randomState()
+	if err != nil {
+		return nil, err
+	}
+	results := make(chan callbackResult, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/callback" {
+			http.NotFound(w, r)
+			return
+		}
+		query := r.URL.Query()
+		if query.Get("state") != state {
+			// Not the redirect of this login, such as a stale tab or another page probing the port
+			http.Error(w, "OAuth callback has the wrong state", http.StatusBadRequest)
+			return
+		}
+		var result callbackResult
+		switch {
+		case query.Get("error") != "":
+			result.err = fmt.Errorf("Access was not granted: %s", orDefault(query.Get("error_description"), query.Get("error")))
+		default:
+			result.code = query.Get("code")
+		}
+		if result.err != nil {
+			http.Error(w, result.err.Error(), http.StatusBadRequest)
+		} else {
+			fmt.Fprintln(w, "jirate is logged in. You can close this window.")
+		}
+		select {
+		case results <- result:
+		default:
+		}
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	scopes := o.Scopes
+	if len(scopes) == 0 {
+		scopes = DefaultScopes
+	}
+	authURL := orDefault(o.AuthURL, AtlassianAuthURL) + "?" + url.Values{
+		"audience":      {"api.atlassian.com"},
+		"client_id":     {o.ClientID},
+		"scope":         {strings.Join(scopes, " ")},
+		"redirect_uri":  {redirectURI},
+		"state":         {state},
+		"response_type": {"code"},
+		"prompt":        {"consent"},
+	}.Encode()
+	open := o.Open
+	if open == nil {
+		open = OpenBrowser
+	}
+	fmt.Fprintf(os.Stderr, "Grant jirate access to Jira in your browser. If it does not open, visit:\n%s\n", authURL)
+	if err := open(authURL); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open the browser: %v\n", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, loginTimeout)
+	defer cancel()
+	var result callbackResult
+	select {
+	case result = <-results:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("Gave up waiting for access to be granted: %w", ctx.Err())
+	}
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	token, err := o.requestToken(ctx, map[string]string{
+		"grant_type":   "authorization_code",
+		"code":         result.code,
+		"redirect_uri": redirectURI,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if token.CloudID, err = o.cloudID(ctx, token.AccessToken); err != nil {
+		return nil, err
+	}
+	if err = o.Store.Save(token); err != nil {
+		return nil, fmt.Errorf("Failed to store the OAuth token: %v", err)
+	}
+	o.token = token
+	return token, nil
+}
+
+// refresh exchanges the refresh token for a new token. Atlassian rotates refresh tokens, so the new one is stored.
+func (o *OAuth) refresh(ctx context.Context) error {
+	token, err := o.requestToken(ctx, map[string]string{
+		"grant_type":    "refresh_token",
+		"refresh_token": o.token.RefreshToken,
+	})
+	if err != nil {
+		return err
+	}
+	token.CloudID = o.token.CloudID
+	if token.RefreshToken == "" {
+		token.RefreshToken = o.token.RefreshToken
+	}
+	if err = o.Store.Save(token); err != nil {
+		return fmt.Errorf("Failed to store the OAuth token: %v", err)
+	}
+	o.token = token
+	return nil
+}
+
+// requestToken sends a token request with the client credentials added to the parameters
+func (o *OAuth) requestToken(ctx context.Context, params map[string]string) (*Token, error) {
+	params["client_id"] = o.ClientID
+	params["client_secret"] = o.ClientSecret
+	body, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequestWithContext(ctx, "POST", orDefault(o.TokenURL, AtlassianTokenURL), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	var response struct {
+		AccessToken      string `json:"access_token"`
+		RefreshToken     string `json:"refresh_token"`
+		ExpiresIn        int    `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	status, err := o.do(request, &response)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK || response.AccessToken == "" {
+		return nil, fmt.Errorf("OAuth token request failed with status %d: %s", status,
+			orDefault(response.ErrorDescription, response.Error))
+	}
+
+	token := &Token{
+		AccessToken:  response.AccessToken,
+		RefreshToken: response.RefreshToken,
+	}
+	if response.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(response.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+// cloudID finds the ID of the configured site among the sites the token grants access to
+func (o *OAuth) cloudID(ctx context.Context, accessToken string) (string, error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", orDefault(o.ResourcesURL, AtlassianResourcesURL), nil)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Authorization", "Bearer "+accessToken)
+	var resources []struct {
+		ID  string `json:"id"`
+		URL string `json:"url"`
+	}
+	status, err := o.do(request, &resources)
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK {
+		return "", fmt.Errorf("Failed to list the sites the OAuth token grants access to, status %d", status)
+	}
+
+	site := strings.TrimSuffix(o.Site, "/")
+	for _, resource := range resources {
+		if strings.EqualFold(strings.TrimSuffix(resource.URL, "/"), site) {
+			return resource.ID, nil
+		}
+	}
+	if site == "" && len(resources) == 1 {
+		return resources[0].ID, nil
+	}
+	return "", fmt.Errorf("Access to %s was not granted to the OAuth app", site)
+}
+
+func (o *OAuth) do(request *http.Request, result any) (int, error) {
+	client := o.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	if err = json.NewDecoder(response.Body).Decode(result); err != nil && response.StatusCode == http.StatusOK {
+		return 0, fmt.Errorf("Failed to parse the response of %s: %v", request.URL, err)
+	}
+	return response.StatusCode, nil
+}
+
+// oauthTransport adds the current access token to requests
+type oauthTransport struct {
+	oauth *OAuth
+}
+
+func (t *oauthTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	token, err := t.oauth.Token(request.Context())
+	if err != nil {
+		return nil, err
+	}
+	authorized := request.Clone(request.Context())
+	authorized.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	return http.DefaultTransport.RoundTrip(authorized)
+}
+
+// OpenBrowser opens a URL in the default browser
+func OpenBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	return cmd.Start()
+}
+
+func randomState() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,410 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// atlassian stands in for Atlassian's authorization server and the accessible resources endpoint
+type atlassian struct {
+	t         *testing.T
+	mu        sync.Mutex
+	grants    []map[string]string // Parameters of the token requests, in order
+	refreshed bool                // Whether refresh requests succeed
+	rotate    bool                // Whether refreshes hand out a new refresh token
+	resources []map[string]string
+}
+
+func (a *atlassian) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/oauth/token":
+		var params map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			a.t.Errorf("Token request is not JSON: %v", err)
+		}
+		if params["client_id"] != "client" || params["client_secret"] != "secret" {
+			a.t.Errorf("Token request has client %q and secret %q", params["client_id"], params["client_secret"])
+		}
+		a.mu.Lock()
+		a.grants = append(a.grants, params)
+		n := len(a.grants)
+		a.mu.Unlock()
+
+		response := map[string]any{"access_token": "access-" + strconv.Itoa(n), "expires_in": 3600}
+		switch params["grant_type"] {
+		case "authorization_code":
+			if params["code"] != "the-code" {
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+				return
+			}
+			response["refresh_token"] = "refresh-" + strconv.Itoa(n)
+		case "refresh_token":
+			if !a.refreshed {
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(map[string]string{"error": "unauthorized_client",
+					"error_description": "refresh_token is invalid"})
+				return
+			}
+			if a.rotate {
+				response["refresh_token"] = "refresh-" + strconv.Itoa(n)
+			}
+		}
+		json.NewEncoder(w).Encode(response)
+	case "/resources":
+		if r.Header.Get("Authorization") == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(a.resources)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (a *atlassian) grantTypes() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var types []string
+	for _, grant := range a.grants {
+		types = append(types, grant["grant_type"])
+	}
+	return types
+}
+
+// freePort finds a port for the callback server
+func freePort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// newOAuth sets up an OAuth app talking to the stand-in server. Its browser grants access right away.
+func newOAuth(t *testing.T, store TokenStore) (*OAuth, *atlassian) {
+	t.Helper()
+	site := &atlassian{t: t, resources: []map[string]string{
+		{"id": "other-cloud", "url": "https://other.atlassian.net"},
+		{"id": "example-cloud", "url": "https://example.atlassian.net"},
+	}}
+	server := httptest.NewServer(site)
+	t.Cleanup(server.Close)
+
+	o := &OAuth{
+		ClientID:     "client",
+		ClientSecret: "secret",
+		CallbackPort: freePort(t),
+		Site:         "https://Example.atlassian.net/",
+		Store:        store,
+		AuthURL:      server.URL + "/authorize",
+		TokenURL:     server.URL + "/oauth/token",
+		ResourcesURL: server.URL + "/resources",
+		APIURL:       server.URL + "/ex/jira/",
+		HTTPClient:   server.Client(),
+	}
+	o.Open = func(authURL string) error {
+		// The user grants access, so the browser follows the redirect back to the callback server
+		link, err := url.Parse(authURL)
+		if err != nil {
+			return err
+		}
+		query := link.Query()
+		if query.Get("client_id") != "client" || query.Get("response_type") != "code" {
+			t.Errorf("Authorization URL %s", authURL)
+		}
+		callback := query.Get("redirect_uri") + "?" + url.Values{
+			"code":  {"the-code"},
+			"state": {query.Get("state")},
+		}.Encode()
+		go func() {
+			if response, err := http.Get(callback); err == nil {
+				response.Body.Close()
+			}
+		}()
+		return nil
+	}
+	return o, site
+}
+
+func TestOAuthLogin(t *testing.T) {
+	store := &MemoryTokenStore{}
+	o, site := newOAuth(t, store)
+
+	token, err := o.Login(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored, err := o.Token(context.Background()); err != nil || stored != token {
+		t.Errorf("Token returned %+v, %v, want the token of the login", stored, err)
+	}
+	if token.AccessToken != "access-1" || token.RefreshToken != "refresh-1" || token.CloudID != "example-cloud" {
+		t.Errorf("Logged in with %+v", token)
+	}
+	if store.Token != token {
+		t.Errorf("Stored %+v, want the new token", store.Token)
+	}
+	grant := site.grants[0]
+	if grant["grant_type"] != "authorization_code" || grant["code"] != "the-code" ||
+		grant["redirect_uri"] != "http://localhost:"+strconv.Itoa(o.CallbackPort)+"/callback" {
+		t.Errorf("Exchanged the code with %v", grant)
+	}
+
+	baseURL, err := o.BaseURL(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if baseURL != o.APIURL+"example-cloud" {
+		t.Errorf("BaseURL %s, want %s", baseURL, o.APIURL+"example-cloud")
+	}
+}
+
+func TestOAuthLoginReplacesStoredToken(t *testing.T) {
+	store := &MemoryTokenStore{Token: &Token{AccessToken: "stored", Expiry: time.Now().Add(time.Hour)}}
+	o, _ := newOAuth(t, store)
+
+	token, err := o.Login(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "access-1" || store.Token.AccessToken != "access-1" {
+		t.Errorf("Login returned %+v and stored %+v, want the new token", token, store.Token)
+	}
+}
+
+func TestOAuthLoginIgnoresWrongState(t *testing.T) {
+	o, _ := newOAuth(t, &MemoryTokenStore{})
+	grant := o.Open
+	o.Open = func(authURL string) error {
+		link, err := url.Parse(authURL)
+		if err != nil {
+			return err
+		}
+		forged := link.Query().Get("redirect_uri") + "?" + url.Values{
+			"code":  {"forged-code"},
+			"state": {"forged-state"},
+		}.Encode()
+		response, err := http.Get(forged)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusBadRequest {
+			t.Errorf("Callback with the wrong state answered %s, want 400", response.Status)
+		}
+		// The login goes on, so the real redirect still completes it
+		return grant(authURL)
+	}
+
+	token, err := o.Login(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "access-1" {
+		t.Errorf("Logged in with %+v, want the token of the real code", token)
+	}
+}
+
+func TestOAuthRefresh(t *testing.T) {
+	tests := []struct {
+		name    string
+		rotate  bool
+		refresh string // The refresh token stored afterwards
+	}{
+		{name: "rotated", rotate: true, refresh: "refresh-1"},
+		{name: "kept", rotate: false, refresh: "old-refresh"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := &MemoryTokenStore{Token: &Token{
+				AccessToken:  "expired",
+				RefreshToken: "old-refresh",
+				Expiry:       time.Now().Add(-time.Minute),
+				CloudID:      "example-cloud",
+			}}
+			o, site := newOAuth(t, store)
+			site.refreshed, site.rotate = true, test.rotate
+			o.Open = func(string) error {
+				t.Error("Logged in instead of refreshing")
+				return nil
+			}
+
+			token, err := o.Token(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token.AccessToken != "access-1" || token.RefreshToken != test.refresh || token.CloudID != "example-cloud" {
+				t.Errorf("Refreshed to %+v", token)
+			}
+			if store.Token != token {
+				t.Errorf("Stored %+v, want the refreshed token", store.Token)
+			}
+			if grant := site.grants[0]; grant["grant_type"] != "refresh_token" || grant["refresh_token"] != "old-refresh" {
+				t.Errorf("Refreshed with %v", grant)
+			}
+
+			// The token is fresh now, so it is used as it is
+			if again, err := o.Token(context.Background()); err != nil || again != token {
+				t.Errorf("Token returned %+v, %v, want the refreshed token", again, err)
+			}
+			if len(site.grants) != 1 {
+				t.Errorf("Sent %d token requests, want 1", len(site.grants))
+			}
+		})
+	}
+}
+
+func TestOAuthLoginRequired(t *testing.T) {
+	tests := []struct {
+		name  string
+		token *Token
+	}{
+		{name: "no token"},
+		{name: "expired without refresh token", token: &Token{AccessToken: "expired", Expiry: time.Now().Add(-time.Minute)}},
+		{
+			name: "refresh fails",
+			token: &Token{
+				AccessToken:  "expired",
+				RefreshToken: "revoked",
+				Expiry:       time.Now().Add(-time.Minute),
+				CloudID:      "example-cloud",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			o, site := newOAuth(t, &MemoryTokenStore{Token: test.token})
+			o.Open = func(string) error {
+				t.Error("Token started a login")
+				return nil
+			}
+
+			if _, err := o.Token(context.Background()); !errors.Is(err, ErrLoginRequired) {
+				t.Errorf("Token returned %v, want %v", err, ErrLoginRequired)
+			}
+			for _, grant := range site.grantTypes() {
+				if grant != "refresh_token" {
+					t.Errorf("Token sent a %s token request", grant)
+				}
+			}
+		})
+	}
+}
+
+func TestOAuthRequestWithExpiredToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("A request without a valid token reached the site")
+	}))
+	defer server.Close()
+
+	store := &MemoryTokenStore{Token: &Token{AccessToken: "access", Expiry: time.Now().Add(time.Hour)}}
+	o, _ := newOAuth(t, store)
+	client, err := o.Client(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Open = func(string) error {
+		t.Error("A request started a login")
+		return nil
+	}
+
+	// The token expires while the client is in use
+	o.token.Expiry = time.Now().Add(-time.Minute)
+	if _, err := client.Get(server.URL); !errors.Is(err, ErrLoginRequired) {
+		t.Errorf("Get returned %v, want %v", err, ErrLoginRequired)
+	}
+}
+
+func TestOAuthCloudID(t *testing.T) {
+	tests := []struct {
+		name      string
+		site      string
+		resources []map[string]string
+		cloudID   string // Empty when no site matches
+	}{
+		{name: "exact", site: "https://example.atlassian.net", cloudID: "example-cloud"},
+		{name: "case and trailing slash", site: "https://EXAMPLE.atlassian.net/", cloudID: "example-cloud"},
+		{name: "not granted", site: "https://missing.atlassian.net"},
+		{
+			name:      "only site",
+			resources: []map[string]string{{"id": "only-cloud", "url": "https://only.atlassian.net"}},
+			cloudID:   "only-cloud",
+		},
+		{name: "no site among several"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			o, site := newOAuth(t, &MemoryTokenStore{})
+			o.Site = test.site
+			if test.resources != nil {
+				site.resources = test.resources
+			}
+
+			cloudID, err := o.cloudID(context.Background(), "access")
+			if test.cloudID == "" {
+				if err == nil {
+					t.Errorf("cloudID returned %q, want an error", cloudID)
+				}
+				return
+			}
+			if err != nil || cloudID != test.cloudID {
+				t.Errorf("cloudID returned %q, %v, want %q", cloudID, err, test.cloudID)
+			}
+		})
+	}
+}
+
+func TestAuthorizationHeaders(t *testing.T) {
+	var header string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	oauth, _ := newOAuth(t, &MemoryTokenStore{Token: &Token{AccessToken: "access", Expiry: time.Now().Add(time.Hour)}})
+	tests := []struct {
+		name   string
+		method Method
+		want   string
+	}{
+		{
+			name:   "basic",
+			method: &Basic{Username: "me@example.com", Password: "api-token"},
+			want:   "Basic " + base64.StdEncoding.EncodeToString([]byte("me@example.com:api-token")),
+		},
+		{name: "bearer", method: &Bearer{Token: "personal-token"}, want: "Bearer personal-token"},
+		{name: "oauth", method: oauth, want: "Bearer access"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, err := test.method.Client(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			response, err := client.Get(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			response.Body.Close()
+			if header != test.want {
+				t.Errorf("Authorization: %q, want %q", header, test.want)
+			}
+		})
+	}
+}
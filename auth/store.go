@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Token is an OAuth access token along with what is needed to refresh it
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Expiry       time.Time `json:"expiry"`
+	CloudID      string    `json:"cloud_id"` // The Jira Cloud site the token was granted for
+}
+
+// expiresWithin reports whether the token expires before d has passed
+func (t *Token) expiresWithin(d time.Duration) bool {
+	return !t.Expiry.IsZero() && time.Now().Add(d).After(t.Expiry)
+}
+
+// TokenStore keeps the OAuth token of a profile between runs
+type TokenStore interface {
+	// Load returns the stored token, or nil when there is none
+	Load() (*Token, error)
+	Save(*Token) error
+	Delete() error
+}
+
+// FileTokenStore stores a token as JSON in a file only the user can read
+type FileTokenStore struct {
+	Path string
+}
+
+func (s FileTokenStore) Load() (*Token, error) {
+	content, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	token := new(Token)
+	if err = json.Unmarshal(content, token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (s FileTokenStore) Save(token *Token) error {
+	content, err := json.MarshalIndent(token, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(s.Path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(s.Path, content, 0600)
+}
+
+func (s FileTokenStore) Delete() error {
+	if err := os.Remove(s.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// MemoryTokenStore keeps a token in memory, such as one that is only stored once it proved to work
+type MemoryTokenStore struct {
+	Token *Token
+}
+
+func (s *MemoryTokenStore) Load() (*Token, error) {
+	return s.Token, nil
+}
+
+func (s *MemoryTokenStore) Save(token *Token) error {
+	s.Token = token
+	return nil
+}
+
+func (s *MemoryTokenStore) Delete() error {
+	s.Token = nil
+	return nil
+}
@@ -0,0 +1,327 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/thaddeusrhatcher/jirate/auth"
+	"github.com/thaddeusrhatcher/jirate/config"
+	"github.com/thaddeusrhatcher/jirate/editor"
+	"github.com/thaddeusrhatcher/jirate/jira"
+)
+
+var authCmd = &cobra.Command{
+	Use:   "auth",
+	Short: "Commands for logging in to Jira sites.",
+}
+
+var loginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "Set up a profile interactively and check that it can log in",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return login(cmd.Context())
+	},
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show who the profile is logged in as, and on which site",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		j, err := jira.NewClient(cmd.Context(), profile)
+		if err != nil {
+			return err
+		}
+		user, err := j.GetMyAccount(cmd.Context())
+		if err != nil {
+			return err
+		}
+		site := string(j.Config.Deployment)
+		if info, err := j.ServerInfo(cmd.Context()); err == nil {
+			site = fmt.Sprintf("%s %s", orDefault(info.DeploymentType, "Server"), info.Version)
+		}
+		fmt.Printf("Profile: %s\nSite:    %s (%s)\nAuth:    %s\nUser:    %s <%s>\nAccount: %s\n",
+			j.Config.Profile, j.Config.Url, site, describeAuth(j.Config.Auth),
+			user.DisplayName, user.EmailAddress, j.UserID(*user))
+		return nil
+	},
+}
+
+var logoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "Remove the stored token, password and OAuth token of the profile",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		removed, err := logout()
+		if err != nil {
+			return err
+		}
+		for _, item := range removed {
+			fmt.Println("Removed", item)
+		}
+		return nil
+	},
+}
+
+// login asks for the site and credentials of a profile, checks them against Jira and saves the profile
+func login(ctx context.Context) error {
+	file, err := config.ReadConfig()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if file == nil {
+		file = &config.File{}
+	}
+	existing, _ := file.Profile(profile)
+	if existing == nil {
+		existing = &config.Profile{Name: profile}
+		if existing.Name == "" {
+			existing.Name = config.DefaultName
+		}
+	}
+
+	site := []editor.Field{
+		{Label: "Profile name", Value: existing.Name, Validate: profileName},
+		{Label: "Jira site", Value: existing.Url, Placeholder: "example.atlassian.net", Validate: required},
+		{Label: "Deployment", Value: orDefault(existing.Deployment, "auto"), Placeholder: "auto, cloud or server",
+			Validate: oneOf("auto", "cloud", "server", "datacenter")},
+		{Label: "Authentication", Value: orDefault(existing.Auth, config.AuthBasic), Placeholder: "basic, bearer or oauth",
+			Validate: oneOf(config.AuthBasic, config.AuthBearer, config.AuthOAuth)},
+	}
+	if ok, err := editor.Prompt("Log in to Jira", site); err != nil || !ok {
+		return orCancelled(err)
+	}
+
+	p := *existing
+	p.Name = site[0].Value
+	p.Url = config.NormalizeURL(site[1].Value)
+	p.Deployment = strings.ToLower(site[2].Value)
+	if p.Deployment == "auto" {
+		p.Deployment = ""
+	}
+	p.Auth = strings.ToLower(site[3].Value)
+
+	passphrase := editor.Field{Label: "Passphrase to encrypt the token", Secret: true,
+		Placeholder: "leave empty to store the token in config.yaml"}
+	var credentials []editor.Field
+	switch p.Auth {
+	case config.AuthBasic:
+		credentials = []editor.Field{
+			{Label: "Username (your account email on Jira Cloud)", Value: p.Username, Validate: required},
+			{Label: "API token", Secret: true, Validate: required},
+			passphrase,
+		}
+	case config.AuthBearer:
+		credentials = []editor.Field{
+			{Label: "Personal Access Token", Secret: true, Validate: required},
+			passphrase,
+		}
+	case config.AuthOAuth:
+		oauth := p.OAuth
+		if oauth == nil {
+			oauth = &config.OAuth{}
+		}
+		credentials = []editor.Field{
+			{Label: "OAuth client ID", Value: oauth.ClientID, Validate: required},
+			{Label: "OAuth client secret", Value: oauth.ClientSecret, Secret: true, Validate: required},
+			{Label: "Callback port", Value: strconv.Itoa(orDefaultPort(oauth.CallbackPort)), Validate: port},
+		}
+	}
+	if ok, err := editor.Prompt("Credentials for "+p.Url, credentials); err != nil || !ok {
+		return orCancelled(err)
+	}
+
+	switch p.Auth {
+	case config.AuthBasic:
+		p.Username = credentials[0].Value
+		p.Password = credentials[1].Value
+	case config.AuthBearer:
+		p.Username = ""
+		p.Password = credentials[0].Value
+	case config.AuthOAuth:
+		callbackPort, _ := strconv.Atoi(credentials[2].Value)
+		p.OAuth = &config.OAuth{
+			ClientID:     credentials[0].Value,
+			ClientSecret: credentials[1].Value,
+			CallbackPort: callbackPort,
+		}
+		if existing.OAuth != nil {
+			p.OAuth.Scopes = existing.OAuth.Scopes
+		}
+		p.Username, p.Password = "", ""
+	}
+	p.PasswordCommand, p.PasswordFile = "", ""
+
+	// Check the profile works before saving it. OAuth sends the user to the browser here for a new token, which
+	// only replaces the stored one once it works.
+	var j jira.Jira
+	var newToken *auth.MemoryTokenStore
+	if p.Auth == config.AuthOAuth {
+		newToken = &auth.MemoryTokenStore{}
+		oauth := jira.NewOAuth(&p, newToken)
+		if _, err = oauth.Login(ctx); err != nil {
+			return err
+		}
+		j, err = jira.NewClientWithAuth(ctx, &p, oauth)
+	} else {
+		j, err = jira.NewClientForProfile(ctx, &p)
+	}
+	if err != nil {
+		return err
+	}
+	user, err := j.GetMyAccount(ctx)
+	if err != nil {
+		return err
+	}
+	if newToken != nil {
+		tokenPath, err := config.TokenPath(p.Name)
+		if err != nil {
+			return err
+		}
+		if err = (auth.FileTokenStore{Path: tokenPath}).Save(newToken.Token); err != nil {
+			return fmt.Errorf("Failed to store the OAuth token: %v", err)
+		}
+	}
+
+	// The passphrase is the last field of the token methods
+	if last := credentials[len(credentials)-1]; p.Auth != config.AuthOAuth && last.Value != "" {
+		if p.PasswordFile, err = saveEncryptedToken(p.Name, p.Password, last.Value); err != nil {
+			return err
+		}
+		p.Password = ""
+	}
+	makeDefault := file.DefaultProfile == "" && (len(file.Profiles) == 0 ||
+		(len(file.Profiles) == 1 && file.Profiles[p.Name] != nil))
+	if err = config.SaveProfile(&p, makeDefault); err != nil {
+		return fmt.Errorf("Failed to save the profile: %v", err)
+	}
+	fmt.Printf("Logged in to %s as %s. Saved profile %q.\n", p.Url, user.DisplayName, p.Name)
+	return nil
+}
+
+// saveEncryptedToken writes the token encrypted next to the config file and returns the path for password_file
+func saveEncryptedToken(name, token, passphrase string) (string, error) {
+	content, err := config.EncryptSecret(token, passphrase)
+	if err != nil {
+		return "", err
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	relative := filepath.Join(filepath.Dir(config.ConfigPath), name+".token")
+	if err = os.MkdirAll(filepath.Dir(home+relative), 0700); err != nil {
+		return "", err
+	}
+	if err = os.WriteFile(home+relative, content, 0600); err != nil {
+		return "", fmt.Errorf("Failed to write the encrypted token: %v", err)
+	}
+	return "~" + relative, nil
+}
+
+// logout removes the secrets jirate stores for the profile and returns what was removed
+func logout() ([]string, error) {
+	p, removed, err := config.RemoveSecrets(profile)
+	if err != nil {
+		return nil, err
+	}
+	tokenPath, err := config.TokenPath(p.Name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = os.Stat(tokenPath); err == nil {
+		if err = (auth.FileTokenStore{Path: tokenPath}).Delete(); err != nil {
+			return nil, err
+		}
+		removed = append(removed, tokenPath)
+	}
+
+	if len(removed) == 0 {
+		fmt.Printf("No stored secrets for profile %q.\n", p.Name)
+	}
+	if p.PasswordCommand != "" {
+		fmt.Println("The password_command of the profile is left as it is.")
+	}
+	for _, env := range []string{config.ProfileTokenEnv(p.Name), config.TokenEnv} {
+		if os.Getenv(env) != "" {
+			fmt.Printf("$%s is still set.\n", env)
+		}
+	}
+	return removed, nil
+}
+
+func describeAuth(method auth.Method) string {
+	switch method := method.(type) {
+	case *auth.Basic:
+		return "API token of " + method.Username
+	case *auth.Bearer:
+		return "Personal Access Token"
+	case *auth.OAuth:
+		return "OAuth app " + method.ClientID
+	}
+	return "unknown"
+}
+
+func required(value string) error {
+	if value == "" {
+		return errors.New("required")
+	}
+	return nil
+}
+
+// profileName accepts names that are safe to use in file names, as tokens are stored per profile
+func profileName(value string) error {
+	if value == "" {
+		return errors.New("required")
+	}
+	if !config.ValidProfileName(value) {
+		return errors.New("use letters, digits, '-', '_' or '.', not starting with '.'")
+	}
+	return nil
+}
+
+func oneOf(options ...string) func(string) error {
+	return func(value string) error {
+		for _, option := range options {
+			if strings.EqualFold(value, option) {
+				return nil
+			}
+		}
+		return fmt.Errorf("expected one of %s", strings.Join(options, ", "))
+	}
+}
+
+func port(value string) error {
+	if number, err := strconv.Atoi(value); err != nil || number < 1 || number > 65535 {
+		return errors.New("expected a port number")
+	}
+	return nil
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+func orDefaultPort(port int) int {
+	if port == 0 {
+		return auth.DefaultCallbackPort
+	}
+	return port
+}
+
+func orCancelled(err error) error {
+	if err == nil {
+		return errors.New("Cancelled")
+	}
+	return err
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+
+	"github.com/thaddeusrhatcher/jirate/auth"
+	"github.com/thaddeusrhatcher/jirate/jira"
+)
+
+// Exit codes, so scripts can tell why a command failed
+const (
+	ExitOK           = 0
+	ExitError        = 1   // Any failure without a code of its own
+	ExitUsage        = 2   // Wrong arguments or flags
+	ExitUnauthorized = 3   // Jira rejected the credentials
+	ExitForbidden    = 4   // The user lacks the permission
+	ExitNotFound     = 5   // The issue or comment does not exist, or the user cannot see it
+	ExitValidation   = 6   // Jira refused the content of the request
+	ExitRateLimited  = 7   // Jira throttled the requests
+	ExitTimeout      = 8   // The command took longer than --timeout
+	ExitInterrupted  = 130 // Cancelled with Ctrl+C, as shells report commands killed by SIGINT
+)
+
+// ExitCode picks the exit code for the error a command returned
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	if errors.Is(err, context.Canceled) {
+		return ExitInterrupted
+	}
+	var timeout interface{ Timeout() bool }
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &timeout) && timeout.Timeout()) {
+		return ExitTimeout
+	}
+	if errors.Is(err, auth.ErrLoginRequired) {
+		return ExitUnauthorized
+	}
+	var apiErr *jira.APIError
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.Unauthorized():
+			return ExitUnauthorized
+		case apiErr.Forbidden():
+			return ExitForbidden
+		case apiErr.NotFound():
+			return ExitNotFound
+		case apiErr.Invalid():
+			return ExitValidation
+		case apiErr.RateLimited():
+			return ExitRateLimited
+		}
+		return ExitError
+	}
+	if !rootCmd.SilenceUsage {
+		// Cobra rejected the command line before running the command
+		return ExitUsage
+	}
+	return ExitError
+}
@@ -1,19 +1,53 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/thaddeusrhatcher/jirate/config"
 	"github.com/thaddeusrhatcher/jirate/processor"
 )
 
 var verbose bool
 var issueNumber string
 var useMarkdown bool
+var strict bool
+var profile string
+var timeout time.Duration
+var cancelTimeout context.CancelFunc = func() {}
+
+// defaultTimeout is how long a command may wait for Jira unless --timeout says otherwise
+const defaultTimeout = 2 * time.Minute
 
 var rootCmd = &cobra.Command{
-	Use: "jirate",
+	Use:           "jirate",
+	SilenceErrors: true, // main prints the error and picks the exit code
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		// The arguments were fine, failures from here on are not about usage
+		cmd.Root().SilenceUsage = true
+		// The user may take their time in the editor or the browser, so those commands are not bounded
+		if timeout > 0 && !waitsForUser(cmd, args) {
+			ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
+			cmd.SetContext(ctx)
+			cancelTimeout = cancel
+		}
+	},
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		cancelTimeout()
+	},
+}
+
+// waitsForUser tells whether the command opens the markdown editor or logs in through the browser
+func waitsForUser(cmd *cobra.Command, args []string) bool {
+	if cmd == loginCmd {
+		return true
+	}
+	return (cmd == addCmd || cmd == updateCmd) && len(args) > 1 && args[1] == "md"
 }
 
 var issueCmd = &cobra.Command{
@@ -32,30 +66,30 @@ var getCmd = &cobra.Command{
 	Use:   "get",
 	Short: "Retrieve the specified object from Jira",
 	Args:  cobra.MinimumNArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		issueId := args[0]
 		switch cmd.Parent() {
 		case issueCmd:
-			processor := processor.NewIssueProcessor("get", issueId)
-			issues, err := processor.Process()
+			processor, err := processor.NewIssueProcessor(cmd.Context(), "get", issueId, profile)
 			if err != nil {
-				panic(err)
+				return err
 			}
-			err = processor.Render(issues)
+			issues, err := processor.Process(cmd.Context())
 			if err != nil {
-				fmt.Println(err)
+				return err
 			}
+			return processor.Render(issues)
 		default:
 			fmt.Println("Command unsupported.")
 		}
-
+		return nil
 	},
 }
 
 var addCmd = &cobra.Command{
 	Use:  "add",
 	Args: cobra.MinimumNArgs(2),
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		issueId := args[0]
 		useMarkdown := false
 		var body string
@@ -66,61 +100,73 @@ var addCmd = &cobra.Command{
 		}
 		switch cmd.Parent() {
 		case commentCmd:
-			processor := processor.NewCommentProcessor("add", issueId, useMarkdown)
-			_, err := processor.Process(body)
+			processor, err := processor.NewCommentProcessor(cmd.Context(), "add", issueId, profile, useMarkdown, strict)
 			if err != nil {
-				panic(err)
+				return err
+			}
+			if _, err = processor.Process(cmd.Context(), body); err != nil {
+				return err
 			}
 			fmt.Println("Success!")
 		default:
 			fmt.Println("Command unsupported")
 		}
+		return nil
 	},
 }
 
 var listCmd = &cobra.Command{
-	Use: "list",
-	Run: func(cmd *cobra.Command, args []string) {
+	Use:  "list",
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
 		issueId := args[0]
 		switch cmd.Parent() {
 		case commentCmd:
-			processor := processor.NewCommentProcessor("list", issueId, false)
-			comments, err := processor.Process("")
+			processor, err := processor.NewCommentProcessor(cmd.Context(), "list", issueId, profile, false, false)
+			if err != nil {
+				return err
+			}
+			comments, err := processor.Process(cmd.Context(), "")
 			if err != nil {
-				fmt.Println("Failed to retrieve comments: ", err)
+				return err
 			}
 			if err = processor.Render(comments); err != nil {
-				fmt.Println("Failed renderring comments: ", err)
+				return fmt.Errorf("Failed renderring comments: %w", err)
 			}
 		default:
 			fmt.Println("Command unsupported")
 		}
+		return nil
 	},
 }
 
 var deleteCmd = &cobra.Command{
-	Use: "delete",
-	Run: func(cmd *cobra.Command, args []string) {
+	Use:  "delete",
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
 		issueId := args[0]
 		commentId := args[1]
 		switch cmd.Parent() {
 		case commentCmd:
-			processor := processor.NewCommentProcessor("delete", issueId, false)
-			_, err := processor.Process(commentId)
+			processor, err := processor.NewCommentProcessor(cmd.Context(), "delete", issueId, profile, false, false)
 			if err != nil {
-				fmt.Println("Failed to delete comments: ", err)
-				return
+				return err
+			}
+			if _, err = processor.Process(cmd.Context(), commentId); err != nil {
+				return err
 			}
 			fmt.Println("Success!")
 		default:
 			fmt.Println("Command unsupported")
 		}
+		return nil
 	},
 }
 
 var updateCmd = &cobra.Command{
-	Use: "update",
-	Run: func(cmd *cobra.Command, args []string) {
+	Use:  "update",
+	Args: cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
 		issueId := args[0]
 		useMarkdown := false
 		var body string
@@ -131,28 +177,83 @@ var updateCmd = &cobra.Command{
 		}
 		switch cmd.Parent() {
 		case commentCmd:
-			processor := processor.NewCommentProcessor("update", issueId, useMarkdown)
-			_, err := processor.Process(body)
+			processor, err := processor.NewCommentProcessor(cmd.Context(), "update", issueId, profile, useMarkdown, strict)
 			if err != nil {
-				panic(err)
+				return err
+			}
+			if _, err = processor.Process(cmd.Context(), body); err != nil {
+				return err
 			}
 			fmt.Println("Success!")
 		default:
 			fmt.Println("Command unsupported")
 		}
+		return nil
+	},
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Commands for managing the Jirate configuration.",
+}
+
+var encryptTokenCmd = &cobra.Command{
+	Use:   "encrypt-token {file}",
+	Short: "Encrypt an API token with a passphrase, for the password_file of a profile",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		token, err := config.ReadSecret("API token: ")
+		if err != nil {
+			return err
+		}
+		passphrase, err := config.ReadSecret("Passphrase: ")
+		if err == nil {
+			var confirmation string
+			if confirmation, err = config.ReadSecret("Repeat passphrase: "); err == nil && confirmation != passphrase {
+				err = errors.New("Passphrases do not match")
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		content, err := config.EncryptSecret(token, passphrase)
+		if err == nil {
+			err = os.WriteFile(args[0], content, 0600)
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to write the encrypted token: %w", err)
+		}
+		fmt.Printf("Saved. Set password_file: %s in your profile.\n", args[0])
+		return nil
 	},
 }
 
 func NewRoot() *cobra.Command {
+	rootCmd.PersistentFlags().StringVar(&profile, "profile", "",
+		"Config profile to use, defaults to $"+config.ProfileEnv+" or the default profile of the config file")
+	rootCmd.PersistentFlags().DurationVar(&timeout, "timeout", defaultTimeout,
+		"Time limit for the requests of a command to Jira, including retries, 0 for none. "+
+			"Commands that open the editor or the browser have none")
 	addCmd.Flags().Bool("md", false, "Whether to use markdown editor")
+	addCmd.Flags().BoolVar(&strict, "strict", false, "Fail instead of degrading markdown that Jira cannot represent")
+	updateCmd.Flags().BoolVar(&strict, "strict", false, "Fail instead of degrading markdown that Jira cannot represent")
 	commentCmd.AddCommand(getCmd)
 	commentCmd.AddCommand(listCmd)
 	commentCmd.AddCommand(addCmd)
 	commentCmd.AddCommand(updateCmd)
 	commentCmd.AddCommand(deleteCmd)
 
+	configCmd.AddCommand(encryptTokenCmd)
+
+	authCmd.AddCommand(loginCmd)
+	authCmd.AddCommand(statusCmd)
+	authCmd.AddCommand(logoutCmd)
+
 	issueCmd.AddCommand(getCmd)
 	rootCmd.AddCommand(issueCmd)
 	rootCmd.AddCommand(commentCmd)
+	rootCmd.AddCommand(configCmd)
+	rootCmd.AddCommand(authCmd)
 	return rootCmd
 }
@@ -1,20 +1,268 @@
 package config
 
 import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ConfigPath  = "/.config/jirate/config.yaml"
+	LegacyPath  = "/.config/jirate/config.txt" // Single site key:value file, read when there is no config.yaml
+	ProfileEnv  = "JIRATE_PROFILE"             // Selects the profile when --profile is not given
+	DefaultName = "default"                    // Profile used when none is selected and the file names no default
+	TokensDir   = "/.config/jirate/tokens/"    // OAuth tokens, one file per profile
+)
+
+// Authentication methods a profile can use
+const (
+	AuthBasic  = "basic"  // Username and API token, the default
+	AuthBearer = "bearer" // Personal Access Token on Jira Data Center
+	AuthOAuth  = "oauth"  // OAuth 2.0 (3LO) app on Jira Cloud
 )
 
-const ConfigPath = "/.config/jirate/config.txt"
+// Profile holds the settings for one Jira site
+type Profile struct {
+	Name              string   `yaml:"-"`
+	Url               string   `yaml:"url,omitempty"`
+	Username          string   `yaml:"username,omitempty"`
+	Password          string   `yaml:"password,omitempty"`            // Plain text API token, prefer one of the sources below
+	PasswordCommand   string   `yaml:"password_command,omitempty"`    // Shell command printing the API token, e.g. pass show jira
+	PasswordFile      string   `yaml:"password_file,omitempty"`       // API token encrypted with a passphrase by jirate config encrypt-token
+	Deployment        string   `yaml:"deployment,omitempty"`          // cloud, server or datacenter, detected when empty or auto
+	Auth              string   `yaml:"auth,omitempty"`                // basic, bearer or oauth, basic when empty
+	OAuth             *OAuth   `yaml:"oauth,omitempty"`               // The OAuth app, required for auth: oauth
+	SmartLinkProjects []string `yaml:"smart_link_projects,omitempty"` // Project keys whose bare issue keys become smart links
+	SmartLinkHosts    []string `yaml:"smart_link_hosts,omitempty"`    // Extra hosts whose bare URLs become smart links
+	RateLimit         float64  `yaml:"rate_limit,omitempty"`          // Requests per second to the site, unlimited when 0
+	RateBurst         int      `yaml:"rate_burst,omitempty"`          // Requests sent at once before rate_limit applies, 1 when 0
+	MaxRetries        *int     `yaml:"max_retries,omitempty"`         // Retries of failed requests, 0 disables them
+}
+
+// OAuth holds the credentials of an OAuth 2.0 (3LO) app created in the Atlassian developer console
+type OAuth struct {
+	ClientID     string   `yaml:"client_id,omitempty"`
+	ClientSecret string   `yaml:"client_secret,omitempty"`
+	Scopes       []string `yaml:"scopes,omitempty"`        // Defaults to reading and writing Jira work, plus offline_access
+	CallbackPort int      `yaml:"callback_port,omitempty"` // Port of the http://localhost:{port}/callback URL registered for the app
+}
+
+// File is the layout of config.yaml:
+//
+//	default_profile: work
+//	profiles:
+//	  work:
+//	    url: https://example.atlassian.net
+//	    username: giga@chad.com
+//	    password: ASDF123
+//	  onprem:
+//	    url: https://jira.example.com
+//	    deployment: server
+//	    ...
+type File struct {
+	DefaultProfile string              `yaml:"default_profile,omitempty"`
+	Profiles       map[string]*Profile `yaml:"profiles,omitempty"`
+}
 
-func GetConfigFile() (*os.File, error) {
+// Load reads the profile with the given name. Without a name the profile comes from JIRATE_PROFILE, then from
+// default_profile, and finally the only profile of the file or the one called default.
+// The legacy config.txt is read as a single profile called default when there is no config.yaml.
+func Load(name string) (*Profile, error) {
+	file, err := ReadConfig()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("No config file found. Please create $HOME%s, or $HOME%s for a single site",
+			ConfigPath, LegacyPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	profile, err := file.Profile(name)
+	if err != nil {
+		return nil, err
+	}
+	if profile.Url == "" {
+		return nil, fmt.Errorf("Missing url in profile %q", profile.Name)
+	}
+	profile.Url = NormalizeURL(profile.Url)
+	if err = profile.validateAuth(); err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// ReadConfig reads config.yaml, or the legacy config.txt when there is none.
+// The error wraps os.ErrNotExist when there is neither.
+func ReadConfig() (*File, error) {
 	home, err := os.UserHomeDir()
 	if err != nil {
 		return nil, err
 	}
-	path := home + ConfigPath
-	file, err := os.Open(path)
+	file, err := readFile(home + ConfigPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return readLegacyFile(home + LegacyPath)
+	}
+	return file, err
+}
+
+// NormalizeURL adds https:// to a bare host and drops the trailing slash
+func NormalizeURL(url string) string {
+	if !strings.Contains(url, "://") {
+		url = "https://" + url
+	}
+	return strings.TrimSuffix(url, "/")
+}
+
+// validateAuth checks the profile has what its authentication method needs, and resolves the token
+func (p *Profile) validateAuth() error {
+	switch strings.ToLower(p.Auth) {
+	case "", AuthBasic:
+		p.Auth = AuthBasic
+		if p.Username == "" {
+			return fmt.Errorf("Missing username in profile %q", p.Name)
+		}
+		return p.resolvePassword()
+	case AuthBearer:
+		p.Auth = AuthBearer
+		return p.resolvePassword()
+	case AuthOAuth:
+		p.Auth = AuthOAuth
+		if p.OAuth == nil || p.OAuth.ClientID == "" || p.OAuth.ClientSecret == "" {
+			return fmt.Errorf("Missing oauth client_id or client_secret in profile %q", p.Name)
+		}
+		return nil
+	}
+	return fmt.Errorf("Unknown auth %q in profile %q, expected %s, %s or %s",
+		p.Auth, p.Name, AuthBasic, AuthBearer, AuthOAuth)
+}
+
+// TokenPath is the file the OAuth token of a profile is stored in
+func TokenPath(profile string) (string, error) {
+	if !ValidProfileName(profile) {
+		return "", invalidProfileName(profile)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return home + TokensDir + profile + ".json", nil
+}
+
+// Profile looks up a profile by name. An empty name selects the profile of JIRATE_PROFILE, or the default profile.
+func (f *File) Profile(name string) (*Profile, error) {
+	if name == "" {
+		name = os.Getenv(ProfileEnv)
+	}
+	if name == "" {
+		name = f.DefaultProfile
+	}
+	if name == "" && len(f.Profiles) == 1 {
+		for only := range f.Profiles {
+			name = only
+		}
+	}
+	if name == "" {
+		name = DefaultName
+	}
+
+	if !ValidProfileName(name) {
+		return nil, invalidProfileName(name)
+	}
+	profile, ok := f.Profiles[name]
+	if !ok || profile == nil {
+		return nil, fmt.Errorf("No profile %q in the config file, available profiles: %s",
+			name, strings.Join(f.Names(), ", "))
+	}
+	profile.Name = name
+	return profile, nil
+}
+
+// ValidProfileName reports whether a profile name is made of letters, digits, '-', '_' and '.', not starting with '.'.
+// Names become file names, such as the one of the OAuth token.
+func ValidProfileName(name string) bool {
+	if name == "" || name[0] == '.' {
+		return false
+	}
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' && r != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+func invalidProfileName(name string) error {
+	return fmt.Errorf("Invalid profile name %q, use letters, digits, '-', '_' or '.' and do not start with '.'", name)
+}
+
+// Names lists the profiles of the file in alphabetical order
+func (f *File) Names() []string {
+	names := make([]string, 0, len(f.Profiles))
+	for name := range f.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func readFile(path string) (*File, error) {
+	content, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
+	file := new(File)
+	if err = yaml.Unmarshal(content, file); err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %v", path, err)
+	}
 	return file, nil
 }
+
+// readLegacyFile reads the key:value config.txt as a single profile.
+// Values may contain colons, blank lines and lines starting with # are skipped.
+func readLegacyFile(path string) (*File, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseLegacy(file, path)
+}
+
+func parseLegacy(r io.Reader, path string) (*File, error) {
+	profile := &Profile{}
+	scanner := bufio.NewScanner(r)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			return nil, fmt.Errorf("%s line %d: expected key:value", path, number)
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "url":
+			profile.Url = value
+		case "username":
+			profile.Username = value
+		case "password":
+			profile.Password = value
+		default:
+			return nil, fmt.Errorf("%s line %d: %s is not read from %s, move the settings to $HOME%s",
+				path, number, strings.TrimSpace(key), filepath.Base(path), ConfigPath)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &File{Profiles: map[string]*Profile{DefaultName: profile}}, nil
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseLegacy(t *testing.T) {
+	file, err := parseLegacy(strings.NewReader("# Work site\nurl: example.atlassian.net\n\nusername:giga@chad.com\n"+
+		"password:ASDF:123\n"), "config.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile, err := file.Profile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Name != DefaultName || profile.Url != "example.atlassian.net" || profile.Username != "giga@chad.com" ||
+		profile.Password != "ASDF:123" {
+		t.Errorf("Parsed %+v, want the default profile with the url, username and password", profile)
+	}
+
+	for _, line := range []string{"deployment:server", "password_command:pass show jira", "rate_limit:5", "no colon"} {
+		if _, err := parseLegacy(strings.NewReader("url:example.atlassian.net\n"+line), "config.txt"); err == nil ||
+			!strings.Contains(err.Error(), "config.txt line 2") {
+			t.Errorf("parseLegacy(%q) returned %v, want an error for line 2", line, err)
+		}
+	}
+}
+
+func TestProfileNames(t *testing.T) {
+	t.Setenv(ProfileEnv, "")
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	file := &File{Profiles: map[string]*Profile{}}
+	for name, valid := range map[string]bool{
+		"work":         true,
+		"on-prem_2.eu": true,
+		"équipe":       true,
+		"":             false,
+		"..":           false,
+		".hidden":      false,
+		"../x":         false,
+		"a/b":          false,
+		`a\b`:          false,
+		"two words":    false,
+	} {
+		if ValidProfileName(name) != valid {
+			t.Errorf("ValidProfileName(%q) = %v, want %v", name, !valid, valid)
+		}
+		if name == "" {
+			continue
+		}
+
+		file.Profiles[name] = &Profile{Url: "https://example.atlassian.net"}
+		if _, err := file.Profile(name); (err == nil) != valid {
+			t.Errorf("Profile(%q) returned %v, want valid %v", name, err, valid)
+		}
+		path, err := TokenPath(name)
+		if (err == nil) != valid {
+			t.Errorf("TokenPath(%q) = %q, %v, want valid %v", name, path, err, valid)
+		}
+		if err == nil && filepath.Dir(path) != filepath.Clean(home+TokensDir) {
+			t.Errorf("TokenPath(%q) = %q, outside of the tokens directory", name, path)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package config
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"unicode"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+const (
+	TokenEnv      = "JIRATE_TOKEN"      // API token for every profile, JIRATE_<PROFILE>_TOKEN sets it for one profile
+	PassphraseEnv = "JIRATE_PASSPHRASE" // Unlocks password_file without prompting
+	secretHeader  = "jirate-secret-v1\n"
+	saltSize      = 16
+)
+
+// ProfileTokenEnv is the environment variable holding the API token of one profile, e.g. JIRATE_ONPREM_TOKEN
+func ProfileTokenEnv(profile string) string {
+	name := strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII || (!unicode.IsLetter(r) && !unicode.IsDigit(r)) {
+			return '_'
+		}
+		return unicode.ToUpper(r)
+	}, profile)
+	return "JIRATE_" + name + "_TOKEN"
+}
+
+// resolvePassword sets the password of the profile from the first source that is configured, in this order:
+// JIRATE_<PROFILE>_TOKEN, JIRATE_TOKEN, password_command, password_file and finally password.
+// A source that is configured but fails is an error, the next source is not tried.
+func (p *Profile) resolvePassword() error {
+	for _, env := range []string{ProfileTokenEnv(p.Name), TokenEnv} {
+		if token := os.Getenv(env); token != "" {
+			p.Password = token
+			return nil
+		}
+	}
+
+	switch {
+	case p.PasswordCommand != "":
+		token, err := runPasswordCommand(p.PasswordCommand)
+		if err != nil {
+			return fmt.Errorf("password_command of profile %q failed: %v", p.Name, err)
+		}
+		p.Password = token
+	case p.PasswordFile != "":
+		token, err := readSecretFile(expandHome(p.PasswordFile))
+		if err != nil {
+			return fmt.Errorf("password_file of profile %q: %v", p.Name, err)
+		}
+		p.Password = token
+	case p.Password == "":
+		return fmt.Errorf("No API token for profile %q. Set $%s or $%s, or one of password, password_command "+
+			"or password_file in the profile", p.Name, ProfileTokenEnv(p.Name), TokenEnv)
+	}
+	return nil
+}
+
+// runPasswordCommand runs a shell command such as "pass show jira" and returns the first line it prints.
+// The command shares the terminal so it can prompt for a passphrase itself.
+func runPasswordCommand(command string) (string, error) {
+	shell, flag := "sh", "-c"
+	if runtime.GOOS == "windows" {
+		shell, flag = "cmd", "/C"
+	}
+	cmd := exec.Command(shell, flag, command)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	token, _, _ := strings.Cut(string(out), "\n")
+	if token = strings.TrimSpace(token); token == "" {
+		return "", errors.New("it printed no token")
+	}
+	return token, nil
+}
+
+func readSecretFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	passphrase, err := Passphrase("Passphrase for " + filepath.Base(path) + ": ")
+	if err != nil {
+		return "", err
+	}
+	return DecryptSecret(content, passphrase)
+}
+
+// Passphrase reads the passphrase from JIRATE_PASSPHRASE, or prompts for it when running in a terminal
+func Passphrase(prompt string) (string, error) {
+	if passphrase := os.Getenv(PassphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+	passphrase, err := ReadSecret(prompt)
+	if err != nil {
+		return "", fmt.Errorf("%v, set $%s instead", err, PassphraseEnv)
+	}
+	return passphrase, nil
+}
+
+// ReadSecret prompts for a value without echoing it
+func ReadSecret(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", errors.New("cannot prompt for a secret without a terminal")
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	secret, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// EncryptSecret encrypts a token with a passphrase for use as a password_file.
+// The key is derived with scrypt and the token sealed with AES-256-GCM.
+func EncryptSecret(secret, passphrase string) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := secretCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	sealed := append(append(salt, nonce...), aead.Seal(nil, nonce, []byte(secret), nil)...)
+	return []byte(secretHeader + base64.StdEncoding.EncodeToString(sealed) + "\n"), nil
+}
+
+// DecryptSecret decrypts the content of a file written by EncryptSecret
+func DecryptSecret(content []byte, passphrase string) (string, error) {
+	if !bytes.HasPrefix(content, []byte(secretHeader)) {
+		return "", errors.New("not an encrypted jirate secret")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content[len(secretHeader):])))
+	if err != nil || len(sealed) < saltSize {
+		return "", errors.New("encrypted secret is corrupted")
+	}
+	aead, err := secretCipher(passphrase, sealed[:saltSize])
+	if err != nil {
+		return "", err
+	}
+	sealed = sealed[saltSize:]
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("encrypted secret is corrupted")
+	}
+	secret, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("wrong passphrase")
+	}
+	return string(secret), nil
+}
+
+func secretCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// expandHome resolves a leading ~/ to the home directory
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return path
+}
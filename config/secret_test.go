@@ -0,0 +1,159 @@
+package config
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// encryptedFile writes the secret encrypted with the passphrase to a temporary password_file
+func encryptedFile(t *testing.T, secret, passphrase string) string {
+	t.Helper()
+	content, err := EncryptSecret(secret, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "token.enc")
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestResolvePassword(t *testing.T) {
+	file := encryptedFile(t, "from-file", "hunter2")
+
+	tests := []struct {
+		name       string
+		profileEnv string // JIRATE_ONPREM_TOKEN
+		tokenEnv   string // JIRATE_TOKEN
+		profile    Profile
+		want       string
+		fails      bool
+	}{
+		{name: "profile variable", profileEnv: "from-profile-env", tokenEnv: "from-env",
+			profile: Profile{PasswordCommand: "echo from-command", PasswordFile: file, Password: "from-config"},
+			want:    "from-profile-env"},
+		{name: "token variable", tokenEnv: "from-env",
+			profile: Profile{PasswordCommand: "echo from-command", PasswordFile: file, Password: "from-config"},
+			want:    "from-env"},
+		{name: "password_command",
+			profile: Profile{PasswordCommand: "echo from-command", PasswordFile: file, Password: "from-config"},
+			want:    "from-command"},
+		{name: "password_file", profile: Profile{PasswordFile: file, Password: "from-config"}, want: "from-file"},
+		{name: "password", profile: Profile{Password: "from-config"}, want: "from-config"},
+		{name: "nothing", fails: true},
+		{name: "failing password_command", profile: Profile{PasswordCommand: "exit 1", Password: "from-config"},
+			fails: true},
+		{name: "empty password_command", profile: Profile{PasswordCommand: "true", Password: "from-config"},
+			fails: true},
+		{name: "missing password_file", profile: Profile{PasswordFile: file + ".missing", Password: "from-config"},
+			fails: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv(ProfileTokenEnv("onprem"), test.profileEnv)
+			t.Setenv(TokenEnv, test.tokenEnv)
+			t.Setenv(PassphraseEnv, "hunter2")
+
+			profile := test.profile
+			profile.Name = "onprem"
+			err := profile.resolvePassword()
+			if test.fails {
+				if err == nil {
+					t.Errorf("resolvePassword set %q, want an error", profile.Password)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if profile.Password != test.want {
+				t.Errorf("resolvePassword set %q, want %q", profile.Password, test.want)
+			}
+		})
+	}
+}
+
+func TestProfileTokenEnv(t *testing.T) {
+	for profile, want := range map[string]string{
+		"onprem":     "JIRATE_ONPREM_TOKEN",
+		"my-site.io": "JIRATE_MY_SITE_IO_TOKEN",
+		"ünï":        "JIRATE__N__TOKEN",
+	} {
+		if got := ProfileTokenEnv(profile); got != want {
+			t.Errorf("ProfileTokenEnv(%q) = %q, want %q", profile, got, want)
+		}
+	}
+}
+
+func TestSecretRoundTrip(t *testing.T) {
+	content, err := EncryptSecret("ATATT3x-token", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "ATATT3x-token") {
+		t.Fatalf("Encrypted secret holds the token in plain text: %s", content)
+	}
+	secret, err := DecryptSecret(content, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret != "ATATT3x-token" {
+		t.Errorf("Decrypted %q, want the token", secret)
+	}
+
+	if secret, err := DecryptSecret(content, "battery staple"); err == nil || err.Error() != "wrong passphrase" {
+		t.Errorf("DecryptSecret with the wrong passphrase = %q, %v, want a wrong passphrase error", secret, err)
+	}
+}
+
+func TestDecryptCorruptedSecret(t *testing.T) {
+	content, err := EncryptSecret("ATATT3x-token", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(strings.TrimPrefix(string(content), secretHeader)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	truncated := func(size int) []byte {
+		return []byte(secretHeader + base64.StdEncoding.EncodeToString(sealed[:size]) + "\n")
+	}
+
+	tests := map[string][]byte{
+		"empty":              nil,
+		"no header":          content[len(secretHeader):],
+		"truncated header":   content[:len(secretHeader)-3],
+		"header only":        []byte(secretHeader),
+		"broken base64":      content[:len(content)-3],
+		"truncated salt":     truncated(saltSize - 1),
+		"truncated nonce":    truncated(saltSize + 5),
+		"no ciphertext":      truncated(saltSize + 12),
+		"truncated tag":      truncated(len(sealed) - 1),
+		"flipped ciphertext": flip(content),
+	}
+
+	for name, corrupted := range tests {
+		t.Run(name, func(t *testing.T) {
+			if secret, err := DecryptSecret(corrupted, "correct horse"); err == nil {
+				t.Errorf("DecryptSecret returned %q, want an error", secret)
+			}
+		})
+	}
+}
+
+// flip changes one character of the encoded ciphertext, keeping it valid base64
+func flip(content []byte) []byte {
+	flipped := append([]byte(nil), content...)
+	i := len(secretHeader) + saltSize*4/3 + 20
+	if flipped[i] == 'A' {
+		flipped[i] = 'B'
+	} else {
+		flipped[i] = 'A'
+	}
+	return flipped
+}
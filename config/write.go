@@ -0,0 +1,176 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SaveProfile writes the profile to config.yaml, replacing a profile of the same name.
+// Other profiles and comments in the file are kept, a legacy config.txt is moved into config.yaml.
+func SaveProfile(profile *Profile, makeDefault bool) error {
+	return updateFile(func(root *yaml.Node) error {
+		value := new(yaml.Node)
+		if err := value.Encode(profile); err != nil {
+			return err
+		}
+		setKey(mapping(root, "profiles"), profile.Name, value)
+		if makeDefault {
+			setKey(root, "default_profile", &yaml.Node{Kind: yaml.ScalarNode, Value: profile.Name})
+		}
+		return nil
+	})
+}
+
+// RemoveSecrets removes the password of a profile from config.yaml and deletes its password_file.
+// It returns the profile as it was, and what was removed.
+func RemoveSecrets(name string) (*Profile, []string, error) {
+	file, err := ReadConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	profile, err := file.Profile(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if profile.Password == "" && profile.PasswordFile == "" {
+		return profile, nil, nil
+	}
+
+	var removed []string
+	err = updateFile(func(root *yaml.Node) error {
+		node := lookup(lookup(root, "profiles"), profile.Name)
+		if node == nil {
+			return fmt.Errorf("No profile %q in the config file", profile.Name)
+		}
+		for _, key := range []string{"password", "password_file"} {
+			if deleteKey(node, key) {
+				removed = append(removed, key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if profile.PasswordFile != "" {
+		path := expandHome(profile.PasswordFile)
+		if err = os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, removed, err
+		}
+		removed = append(removed, path)
+	}
+	return profile, removed, nil
+}
+
+// updateFile edits config.yaml as a YAML tree, so what the edit does not touch stays as it was
+func updateFile(edit func(root *yaml.Node) error) error {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+	path := home + ConfigPath
+
+	doc := new(yaml.Node)
+	legacy := false
+	content, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		file, err := readLegacyFile(home + LegacyPath)
+		if err == nil {
+			legacy = true
+			err = doc.Encode(file)
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	case err != nil:
+		return err
+	default:
+		if err = yaml.Unmarshal(content, doc); err != nil {
+			return fmt.Errorf("Failed to parse %s: %v", path, err)
+		}
+	}
+
+	root := doc
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) > 0 {
+		root = doc.Content[0]
+	}
+	if root.Kind == 0 || (doc.Kind == yaml.DocumentNode && len(doc.Content) == 0) {
+		root = &yaml.Node{Kind: yaml.MappingNode}
+		doc = root
+	}
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s is not a mapping of settings", path)
+	}
+	if err = edit(root); err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err = encoder.Encode(doc); err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	if err = os.WriteFile(path, out.Bytes(), 0600); err != nil {
+		return err
+	}
+	if legacy {
+		fmt.Fprintf(os.Stderr, "Moved $HOME%s into $HOME%s\n", LegacyPath, ConfigPath)
+		return os.Remove(home + LegacyPath)
+	}
+	return nil
+}
+
+// lookup returns the value of a key of a mapping node, or nil
+func lookup(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// mapping returns the mapping under a key, adding it when it is missing or empty
+func mapping(node *yaml.Node, key string) *yaml.Node {
+	value := lookup(node, key)
+	if value == nil {
+		value = &yaml.Node{Kind: yaml.MappingNode}
+		setKey(node, key, value)
+	} else if value.Kind != yaml.MappingNode {
+		*value = yaml.Node{Kind: yaml.MappingNode}
+	}
+	return value
+}
+
+func setKey(node *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content[i+1] = value
+			return
+		}
+	}
+	node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+}
+
+func deleteKey(node *yaml.Node, key string) bool {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package editor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbletea"
+)
+
+// Field is one question of a Prompt
+type Field struct {
+	Label       string
+	Value       string // Initial value, holds the answer once the prompt is submitted
+	Placeholder string
+	Secret      bool               // Hides what is typed, for tokens and passphrases
+	Validate    func(string) error // Keeps the prompt open until the answer passes
+}
+
+type promptModel struct {
+	title     string
+	fields    []Field
+	inputs    []textinput.Model
+	errs      []error
+	focus     int
+	cancelled bool
+}
+
+// Prompt asks the fields one below the other. Tab and the arrow keys move between them,
+// enter moves to the next field and submits on the last one. It reports false when cancelled with ctrl+c or esc.
+func Prompt(title string, fields []Field) (bool, error) {
+	m := promptModel{
+		title:  title,
+		fields: fields,
+		inputs: make([]textinput.Model, len(fields)),
+		errs:   make([]error, len(fields)),
+	}
+	for i, field := range fields {
+		input := textinput.New()
+		input.Placeholder = field.Placeholder
+		input.SetValue(field.Value)
+		input.Width = 60
+		if field.Secret {
+			input.EchoMode = textinput.EchoPassword
+			input.EchoCharacter = '•'
+		}
+		m.inputs[i] = input
+	}
+	m.inputs[0].Focus()
+
+	result, err := tea.NewProgram(m).Run()
+	if err != nil {
+		return false, err
+	}
+	m = result.(promptModel)
+	if m.cancelled {
+		return false, nil
+	}
+	for i := range fields {
+		fields[i].Value = strings.TrimSpace(m.inputs[i].Value())
+	}
+	return true, nil
+}
+
+func (m promptModel) Init() tea.Cmd {
+	return textinput.Blink
+}
+
+func (m promptModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch msg.Type {
+		case tea.KeyCtrlC, tea.KeyEsc:
+			m.cancelled = true
+			return m, tea.Quit
+		case tea.KeyEnter:
+			if !m.validate(m.focus) {
+				return m, nil
+			}
+			if m.focus == len(m.inputs)-1 {
+				for i := range m.inputs {
+					if !m.validate(i) {
+						return m, m.moveTo(i)
+					}
+				}
+				return m, tea.Quit
+			}
+			return m, m.moveTo(m.focus + 1)
+		case tea.KeyTab, tea.KeyDown:
+			return m, m.moveTo((m.focus + 1) % len(m.inputs))
+		case tea.KeyShiftTab, tea.KeyUp:
+			return m, m.moveTo((m.focus + len(m.inputs) - 1) % len(m.inputs))
+		}
+	}
+
+	var cmd tea.Cmd
+	m.inputs[m.focus], cmd = m.inputs[m.focus].Update(msg)
+	return m, cmd
+}
+
+// validate checks the answer of a field and keeps the error to show next to it
+func (m *promptModel) validate(i int) bool {
+	m.errs[i] = nil
+	if validate := m.fields[i].Validate; validate != nil {
+		m.errs[i] = validate(strings.TrimSpace(m.inputs[i].Value()))
+	}
+	return m.errs[i] == nil
+}
+
+func (m *promptModel) moveTo(i int) tea.Cmd {
+	m.inputs[m.focus].Blur()
+	m.focus = i
+	return m.inputs[i].Focus()
+}
+
+func (m promptModel) View() string {
+	var b strings.Builder
+	b.WriteString(m.title + "\n\n")
+	for i, input := range m.inputs {
+		fmt.Fprintf(&b, "%s\n%s\n", m.fields[i].Label, input.View())
+		if m.errs[i] != nil {
+			fmt.Fprintf(&b, "  ! %v\n", m.errs[i])
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("(tab to move, enter to continue, esc to cancel)\n")
+	return b.String()
+}
@@ -0,0 +1,43 @@
+package jira
+
+import (
+	"context"
+	"io"
+
+	"github.com/andygrunwald/go-jira"
+)
+
+// Client is what jirate does with a Jira site. Jira implements it against a real site, tests can point a Jira at
+// the fake site of the jiratest package or substitute their own implementation.
+type Client interface {
+	// GetConfig returns the settings of the site the client talks to
+	GetConfig() Config
+	// UserID is how the REST API of the site refers to users
+	UserID(user jira.User) string
+
+	ServerInfo(ctx context.Context) (*ServerInfo, error)
+	GetMyAccount(ctx context.Context) (*jira.User, error)
+	FindUsers(ctx context.Context, query string) ([]jira.User, error)
+
+	GetIssue(ctx context.Context, issueNumber string) (*jira.Issue, error)
+	GetMyIssues(ctx context.Context) ([]jira.Issue, error)
+
+	GetComments(ctx context.Context, issueNumber string) ([]*jira.Comment, error)
+	GetComment(ctx context.Context, issueNumber, commentId string) (*jira.Comment, error)
+	GetCommentADF(ctx context.Context, issueNumber, commentId string) (*jira.Comment, []byte, error)
+	AddComment(ctx context.Context, issueNumber, content string) error
+	AddCommentCustom(ctx context.Context, issueNumber string, content []byte) error
+	UpdateComment(ctx context.Context, issueNumber, commentId, content string) error
+	UpdateCommentCustom(ctx context.Context, issueNumber, commentId string, content []byte) error
+	DeleteComment(ctx context.Context, issueNumber, commentId string) error
+
+	AddAttachment(ctx context.Context, issueNumber, name string, content io.Reader) (*jira.Attachment, error)
+	GetAttachmentMediaID(ctx context.Context, attachmentId string) (string, error)
+}
+
+var _ Client = Jira{}
+
+// GetConfig returns the settings the client was created with
+func (j Jira) GetConfig() Config {
+	return j.Config
+}
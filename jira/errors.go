@@ -0,0 +1,107 @@
+package jira
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/andygrunwald/go-jira"
+)
+
+// APIError is a request Jira answered with an error status.
+// Messages and FieldErrors hold the errorMessages and errors of the response body, when Jira sent them.
+type APIError struct {
+	Method      string
+	Path        string
+	StatusCode  int
+	Status      string
+	Messages    []string
+	FieldErrors map[string]string // Problems with the request, keyed by field
+}
+
+func (e *APIError) Error() string {
+	var details []string
+	details = append(details, e.Messages...)
+	fields := make([]string, 0, len(e.FieldErrors))
+	for field := range e.FieldErrors {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		details = append(details, fmt.Sprintf("%s: %s", field, e.FieldErrors[field]))
+	}
+
+	message := fmt.Sprintf("%s %s failed with %s", e.Method, e.Path, e.Status)
+	if len(details) > 0 {
+		message += ": " + strings.Join(details, "; ")
+	}
+	return message
+}
+
+// NotFound reports whether the issue, comment or other resource does not exist, or the user cannot see it
+func (e *APIError) NotFound() bool { return e.StatusCode == http.StatusNotFound }
+
+// Unauthorized reports whether Jira rejected the credentials
+func (e *APIError) Unauthorized() bool { return e.StatusCode == http.StatusUnauthorized }
+
+// Forbidden reports whether the user lacks the permission for the request
+func (e *APIError) Forbidden() bool { return e.StatusCode == http.StatusForbidden }
+
+// Invalid reports whether Jira refused the content of the request, such as a malformed comment body
+func (e *APIError) Invalid() bool {
+	return e.StatusCode == http.StatusBadRequest || e.StatusCode == http.StatusUnprocessableEntity
+}
+
+// RateLimited reports whether Jira throttled the request
+func (e *APIError) RateLimited() bool { return e.StatusCode == http.StatusTooManyRequests }
+
+// errorBody is how Jira describes a failed request
+type errorBody struct {
+	ErrorMessages []string          `json:"errorMessages"`
+	Errors        map[string]string `json:"errors"`
+}
+
+// apiError turns the error of a go-jira call into an *APIError when Jira answered.
+// Errors without a response, such as an unreachable site, are returned as they are.
+func apiError(response *jira.Response, err error) error {
+	if err == nil {
+		return nil
+	}
+	if response == nil || response.Response == nil {
+		return err
+	}
+	return newAPIError(response.Response, err)
+}
+
+// newAPIError describes an error response. go-jira's services have already read the body into a *jira.Error,
+// otherwise the body is read here.
+func newAPIError(response *http.Response, err error) *APIError {
+	apiErr := &APIError{
+		StatusCode: response.StatusCode,
+		Status:     response.Status,
+	}
+	if response.Request != nil {
+		apiErr.Method = response.Request.Method
+		apiErr.Path = response.Request.URL.Path
+	}
+
+	var jiraErr *jira.Error
+	if errors.As(err, &jiraErr) {
+		apiErr.Messages = jiraErr.ErrorMessages
+		apiErr.FieldErrors = jiraErr.Errors
+		return apiErr
+	}
+	if response.Body != nil {
+		var body errorBody
+		if content, readErr := io.ReadAll(response.Body); readErr == nil && json.Unmarshal(content, &body) == nil {
+			apiErr.Messages = body.ErrorMessages
+			apiErr.FieldErrors = body.Errors
+		}
+		response.Body.Close()
+	}
+	return apiErr
+}
@@ -1,220 +1,387 @@
 package jira
 
 import (
-	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
 	"strings"
 
 	"github.com/andygrunwald/go-jira"
+	"github.com/thaddeusrhatcher/jirate/auth"
 	"github.com/thaddeusrhatcher/jirate/config"
 )
 
+// Deployment is the kind of Jira site the client talks to
+type Deployment string
+
+const (
+	DeploymentCloud  Deployment = "cloud"  // Jira Cloud, comments are ADF documents of the v3 REST API
+	DeploymentServer Deployment = "server" // Jira Server and Data Center, comments are wiki markup of the v2 REST API
+)
+
+// ErrNeedsCloud is returned by the calls that only Jira Cloud supports, such as posting ADF documents
+var ErrNeedsCloud = errors.New("This needs Jira Cloud, the site is Jira Server or Data Center")
+
+// ServerInfo describes the Jira site, as returned by /rest/api/2/serverInfo
+type ServerInfo struct {
+	BaseUrl        string `json:"baseUrl"`
+	Version        string `json:"version"`
+	VersionNumbers []int  `json:"versionNumbers"`
+	DeploymentType string `json:"deploymentType"` // Cloud, Server or DataCenter, missing on old Server versions
+	ServerTitle    string `json:"serverTitle"`
+}
+
+// Deployment maps the deployment type to the API flavor it speaks
+func (s ServerInfo) Deployment() Deployment {
+	if strings.EqualFold(s.DeploymentType, "Cloud") {
+		return DeploymentCloud
+	}
+	return DeploymentServer
+}
+
 type Config struct {
-	Auth jira.BasicAuthTransport
-	Url  string
+	Profile    string // Name of the config profile
+	Auth       auth.Method
+	Url        string
+	Deployment Deployment
+
+	SmartLinkProjects []string // Project keys whose bare issue keys become smart links in comments
+	SmartLinkHosts    []string // Extra hosts, such as a separate Confluence site, whose bare URLs become smart links
+
+	RateLimit  float64 // Requests per second to the site, shared by every client of the process, unlimited when 0
+	RateBurst  int     // Requests sent at once before RateLimit applies
+	MaxRetries int     // Retries of requests that failed for reasons that pass, such as rate limiting
 }
 
 type Jira struct {
-	client *jira.Client
-	Config Config
+	client     *jira.Client
+	httpClient *http.Client // Authenticates the requests of client
+	Config     Config
 }
 
-func (c *Config) loadConfig() error {
-	file, err := config.GetConfigFile()
-
+func (c *Config) loadConfig(profileName string) error {
+	profile, err := config.Load(profileName)
 	if err != nil {
-		return errors.New(`Failed to open the config file.
-			Please verify the following exists: $HOME/.config/jirate/config.txt.
-		`)
+		return err
 	}
+	return c.fromProfile(profile)
+}
 
-	fileScanner := bufio.NewScanner(file)
-	fileScanner.Split(bufio.ScanLines)
-	vals := make(map[string]string)
-
-	for fileScanner.Scan() {
-		line := fileScanner.Text()
-		l := strings.Split(line, ":")
-		vals[l[0]] = l[1]
+func (c *Config) fromProfile(profile *config.Profile) (err error) {
+	c.Profile = profile.Name
+	c.Url = profile.Url
+	if c.Auth, err = authMethod(profile); err != nil {
+		return err
 	}
-
-	username, ok := vals["username"]
-	if !ok {
-		return errors.New("Missing username in config.txt")
+	c.SmartLinkProjects = profile.SmartLinkProjects
+	c.SmartLinkHosts = profile.SmartLinkHosts
+	c.RateLimit = profile.RateLimit
+	c.RateBurst = profile.RateBurst
+	c.MaxRetries = DefaultMaxRetries
+	if profile.MaxRetries != nil {
+		c.MaxRetries = *profile.MaxRetries
 	}
-	password, ok := vals["password"]
-	if !ok {
-		return errors.New("Missing password in config.txt")
+	switch deployment := strings.ToLower(strings.TrimSpace(profile.Deployment)); deployment {
+	case "", "auto":
+		c.Deployment = "" // Detected once connected
+	case string(DeploymentCloud):
+		c.Deployment = DeploymentCloud
+	case string(DeploymentServer), "datacenter", "data center":
+		c.Deployment = DeploymentServer
+	default:
+		return fmt.Errorf("Unknown deployment %q in profile %q, expected cloud, server or auto", deployment, profile.Name)
 	}
-	url, ok := vals["url"]
-	if !ok {
-		return errors.New("Missing url in config.txt")
+	return nil
+}
+
+// authMethod builds the authentication method the profile selects
+func authMethod(profile *config.Profile) (auth.Method, error) {
+	switch profile.Auth {
+	case config.AuthBearer:
+		return &auth.Bearer{Token: profile.Password}, nil
+	case config.AuthOAuth:
+		tokenPath, err := config.TokenPath(profile.Name)
+		if err != nil {
+			return nil, err
+		}
+		return NewOAuth(profile, auth.FileTokenStore{Path: tokenPath}), nil
 	}
-	c.Auth = jira.BasicAuthTransport{
-		Username: username,
-		Password: password,
+	return &auth.Basic{Username: profile.Username, Password: profile.Password}, nil
+}
+
+// NewOAuth sets up the OAuth app of a profile, keeping its token in the store
+func NewOAuth(profile *config.Profile, store auth.TokenStore) *auth.OAuth {
+	return &auth.OAuth{
+		ClientID:     profile.OAuth.ClientID,
+		ClientSecret: profile.OAuth.ClientSecret,
+		Scopes:       profile.OAuth.Scopes,
+		CallbackPort: profile.OAuth.CallbackPort,
+		Site:         profile.Url,
+		Store:        store,
 	}
-	c.Url = "https://" + url
-	return nil
 }
 
-func NewClient() (Jira, error) {
+// NewClient connects to the Jira site of a config profile, an empty name selects the default profile
+func NewClient(ctx context.Context, profile string) (Jira, error) {
 	config := Config{}
-	err := config.loadConfig()
+	err := config.loadConfig(profile)
 	if err != nil {
 		return Jira{}, err
 	}
-	var j Jira
-	j.client, err = jira.NewClient(config.Auth.Client(), config.Url)
+	return connect(ctx, config)
+}
+
+// NewClientForProfile connects with a profile that is not necessarily saved yet, such as one being logged in with.
+// The profile must hold the resolved token in Password.
+func NewClientForProfile(ctx context.Context, profile *config.Profile) (Jira, error) {
+	config := Config{}
+	if err := config.fromProfile(profile); err != nil {
+		return Jira{}, err
+	}
+	return connect(ctx, config)
+}
+
+// NewClientWithAuth connects like NewClientForProfile, authenticating with the method instead of the profile's
+func NewClientWithAuth(ctx context.Context, profile *config.Profile, method auth.Method) (Jira, error) {
+	config := Config{}
+	if err := config.fromProfile(profile); err != nil {
+		return Jira{}, err
+	}
+	config.Auth = method
+	return connect(ctx, config)
+}
+
+func connect(ctx context.Context, config Config) (j Jira, err error) {
+	j.Config = config
+	if j.httpClient, err = config.Auth.Client(ctx); err != nil {
+		return Jira{}, err
+	}
+	// Retries wrap the authentication, so every attempt is authenticated afresh
+	limiter := sharedLimiter(config.Url, config.RateLimit, config.RateBurst)
+	j.httpClient.Transport = newRetryTransport(j.httpClient.Transport, limiter, config.MaxRetries)
+	// OAuth apps reach the site through api.atlassian.com rather than its own URL
+	baseUrl := config.Url
+	if endpoint, ok := config.Auth.(auth.Endpoint); ok {
+		if baseUrl, err = endpoint.BaseURL(ctx); err != nil {
+			return Jira{}, err
+		}
+	}
+	j.client, err = jira.NewClient(j.httpClient, baseUrl)
 	if err != nil {
-		return Jira{}, nil
+		return Jira{}, err
+	}
+	if j.Config.Deployment == "" {
+		if j.Config.Deployment, err = j.detectDeployment(ctx); err != nil {
+			return Jira{}, err
+		}
 	}
 	return j, nil
 }
 
-func (j Jira) GetIssue(issueNumber string) (*jira.Issue, error) {
-	issue, _, err := j.client.Issue.Get(issueNumber, &jira.GetQueryOptions{
+// detectDeployment tells Jira Cloud from Jira Server and Data Center. Atlassian hosted sites and OAuth apps are
+// always Cloud, other sites are asked for their server info.
+func (j Jira) detectDeployment(ctx context.Context) (Deployment, error) {
+	if _, ok := j.Config.Auth.(*auth.OAuth); ok {
+		return DeploymentCloud, nil
+	}
+	if site, err := url.Parse(j.Config.Url); err == nil {
+		host := strings.ToLower(site.Hostname())
+		if strings.HasSuffix(host, ".atlassian.net") || strings.HasSuffix(host, ".jira.com") {
+			return DeploymentCloud, nil
+		}
+	}
+	info, err := j.ServerInfo(ctx)
+	if err != nil {
+		return "", fmt.Errorf("Failed to detect whether %s is Jira Cloud or Server, set deployment in profile %q: %w",
+			j.Config.Url, j.Config.Profile, err)
+	}
+	return info.Deployment(), nil
+}
+
+// ServerInfo retrieves the version and deployment type of the site
+func (j Jira) ServerInfo(ctx context.Context) (*ServerInfo, error) {
+	request, err := j.client.NewRequestWithContext(ctx, "GET", "/rest/api/2/serverInfo", nil)
+	if err != nil {
+		return nil, err
+	}
+	info := new(ServerInfo)
+	if response, err := j.client.Do(request, info); err != nil {
+		return nil, apiError(response, err)
+	}
+	return info, nil
+}
+
+// UserID is how the REST API refers to users: account IDs on Jira Cloud, usernames on Jira Server and Data Center
+func (j Jira) UserID(user jira.User) string {
+	if j.Config.Deployment == DeploymentServer {
+		return user.Name
+	}
+	return user.AccountID
+}
+
+func (j Jira) GetIssue(ctx context.Context, issueNumber string) (*jira.Issue, error) {
+	issue, response, err := j.client.Issue.GetWithContext(ctx, issueNumber, &jira.GetQueryOptions{
 		Expand: "renderedFields",
 	})
 
 	if err != nil {
-		return nil, nil
+		return nil, apiError(response, err)
 	}
 
 	return issue, nil
 }
 
-func (j Jira) GetMyIssues() ([]jira.Issue, error) {
-	user, err := j.GetMyAccount()
+func (j Jira) GetMyIssues(ctx context.Context) ([]jira.Issue, error) {
+	jql := "assignee = currentUser() AND status = \"In Progress\""
+	issues, response, err := j.client.Issue.SearchWithContext(ctx, jql, &jira.SearchOptions{})
 	if err != nil {
-		return []jira.Issue{}, err
+		return []jira.Issue{}, apiError(response, err)
 	}
-	fmt.Println("user: ", user.AccountID)
-	jql := fmt.Sprintf("assignee=%s&status=\"In Progress\"", user.AccountID)
-	issues, response, err := j.client.Issue.Search(jql, &jira.SearchOptions{})
+	return issues, nil
+}
+
+func (j Jira) GetMyAccount(ctx context.Context) (*jira.User, error) {
+	user, response, err := j.client.User.GetSelfWithContext(ctx)
 	if err != nil {
-		return []jira.Issue{}, err
+		// This is usually the first request made with a new profile, so explain the common mistakes
+		var apiErr *APIError
+		if !errors.As(apiError(response, err), &apiErr) {
+			return nil, fmt.Errorf("Cannot reach %s: %w", j.Config.Url, err)
+		}
+		switch {
+		case apiErr.Unauthorized() || apiErr.Forbidden():
+			return nil, fmt.Errorf("%s rejected the credentials of profile %q: %w", j.Config.Url, j.Config.Profile, apiErr)
+		case apiErr.NotFound():
+			return nil, fmt.Errorf("%s does not look like a Jira site, check the url of profile %q: %w",
+				j.Config.Url, j.Config.Profile, apiErr)
+		}
+		return nil, apiErr
 	}
-	fmt.Printf("Response: \n\tstatus: %s\n\tmax results: %d\n\ttotal: %d\n",
-		response.Status,
-		response.MaxResults,
-		response.Total,
-	)
+	return user, nil
+}
 
-	return issues, nil
+// restPath formats a path of the REST API version of the deployment. Comment bodies are ADF documents in version 3,
+// which only Jira Cloud has, and wiki markup in version 2.
+func (j Jira) restPath(format string, args ...any) string {
+	version := "/rest/api/3"
+	if j.Config.Deployment == DeploymentServer {
+		version = "/rest/api/2"
+	}
+	return version + fmt.Sprintf(format, args...)
 }
 
-func (j Jira) GetMyAccount() (*jira.User, error) {
-	user, response, err := j.client.User.GetSelf()
+// FindUsers searches for users whose username, display name or email address matches the query
+func (j Jira) FindUsers(ctx context.Context, query string) ([]jira.User, error) {
+	// Jira Server searches by username, Jira Cloud has no usernames
+	path := j.restPath("/user/search?query=%s", url.QueryEscape(query))
+	if j.Config.Deployment == DeploymentServer {
+		path = j.restPath("/user/search?username=%s", url.QueryEscape(query))
+	}
+	request, err := j.client.NewRequestWithContext(
+		ctx,
+		"GET",
+		path,
+		nil,
+	)
 	if err != nil {
 		return nil, err
 	}
 
-	if response.StatusCode != 200 {
-		return nil, fmt.Errorf("Failed to get user info. Response: \n\tstatus: %s\n\tmax results: %d\n\ttotal: %d\n",
-			response.Status,
-			response.MaxResults,
-			response.Total,
-		)
+	users := []jira.User{}
+	if response, err := j.client.Do(request, &users); err != nil {
+		return nil, apiError(response, err)
 	}
-
-	return user, err
+	return users, nil
 }
 
-func (j Jira) GetComments(issueNumber string) ([]*jira.Comment, error) {
-	issue, _, err := j.client.Issue.Get(issueNumber, &jira.GetQueryOptions{
+func (j Jira) GetComments(ctx context.Context, issueNumber string) ([]*jira.Comment, error) {
+	issue, response, err := j.client.Issue.GetWithContext(ctx, issueNumber, &jira.GetQueryOptions{
 		Expand: "renderedFields",
 	})
 	if err != nil {
-		return nil, err
+		return nil, apiError(response, err)
+	}
+	if issue.RenderedFields == nil || issue.RenderedFields.Comments == nil {
+		return nil, nil
 	}
-	comments := issue.RenderedFields.Comments.Comments
-	return comments, err
+	return issue.RenderedFields.Comments.Comments, nil
 }
 
-func (j Jira) GetComment(issueNumber, commentId string) (*jira.Comment, error) {
-	path := fmt.Sprintf("/rest/api/3/issue/%s/comment/%s", issueNumber, commentId)
-	request, err := j.client.NewRequest(
+func (j Jira) GetComment(ctx context.Context, issueNumber, commentId string) (*jira.Comment, error) {
+	comment, _, err := j.getComment(ctx, issueNumber, commentId)
+	return comment, err
+}
+
+// GetCommentADF retrieves a comment along with its raw Atlassian Document Format body.
+// The returned comment's Body holds the rendered HTML, the ADF document is returned separately.
+// Jira Server has no ADF, there the document is the comment's wiki markup as a JSON string.
+func (j Jira) GetCommentADF(ctx context.Context, issueNumber, commentId string) (*jira.Comment, []byte, error) {
+	return j.getComment(ctx, issueNumber, commentId)
+}
+
+func (j Jira) getComment(ctx context.Context, issueNumber, commentId string) (*jira.Comment, []byte, error) {
+	path := j.restPath("/issue/%s/comment/%s", issueNumber, commentId)
+	request, err := j.client.NewRequestWithContext(
+		ctx,
 		"GET",
 		path,
 		nil,
 	)
+	if err != nil {
+		return nil, nil, err
+	}
 	query := request.URL.Query()
 	query.Add("expand", "renderedBody")
 	request.URL.RawQuery = query.Encode()
-	if err != nil {
-		return nil, err
-	}
 	rawComment := make(map[string]any)
-	_, err = j.client.Do(request, &rawComment)
+	if response, err := j.client.Do(request, &rawComment); err != nil {
+		return nil, nil, apiError(response, err)
+	}
+	document, err := json.Marshal(rawComment["body"])
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	delete(rawComment, "body")
 	comment := new(jira.Comment)
 	b, err := json.Marshal(rawComment)
-	err = json.Unmarshal(b, comment)
-	comment.Body = rawComment["renderedBody"].(string)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = json.Unmarshal(b, comment); err != nil {
+		return nil, nil, err
+	}
+	comment.Body, _ = rawComment["renderedBody"].(string)
 
-	if false {
-		author, ok := rawComment["author"].(map[string]any)
-		if !ok {
-			return nil, errors.New("Missing 'author' in response body")
-		}
-		accountId := author["accountId"].(string)
-		emailAddress := author["emailAddress"].(string)
-		displayName := author["displayName"].(string)
-		selfLink := author["selfLink"].(string)
-		comment.Author = jira.User{
-			AccountID:    accountId,
-			EmailAddress: emailAddress,
-			DisplayName:  displayName,
-			Self:         selfLink,
-		}
-		selfLink = rawComment["self"].(string)
-		created := rawComment["created"].(string)
-		updated := rawComment["updated"].(string)
-		id := rawComment["id"].(string)
-		comment.Self = selfLink
-		comment.Created = created
-		comment.Updated = updated
-		comment.ID = id
-	}
-	return comment, nil
+	return comment, document, nil
 }
 
-func (j Jira) AddComment(issueNumber, content string) error {
-	_, response, err := j.client.Issue.AddComment(issueNumber, &jira.Comment{
+func (j Jira) AddComment(ctx context.Context, issueNumber, content string) error {
+	_, response, err := j.client.Issue.AddCommentWithContext(ctx, issueNumber, &jira.Comment{
 		Body: content,
 	})
-	if err != nil {
-		fmt.Println(err)
-		return fmt.Errorf("Failed to create comment: %v", err)
-	} else if response.StatusCode != 201 {
-		return fmt.Errorf(
-			"Response: \n\tstatus: %s\n\tmax results: %d\n\ttotal: %d\n",
-			response.Status,
-			response.MaxResults,
-			response.Total,
-		)
-	}
-	return nil
+	return apiError(response, err)
 }
 
-func (j Jira) AddCommentCustom(issueNumber string, content []byte) error {
+func (j Jira) AddCommentCustom(ctx context.Context, issueNumber string, content []byte) error {
+	if j.Config.Deployment == DeploymentServer {
+		return ErrNeedsCloud
+	}
 	data := make(map[string]interface{})
-	err := json.Unmarshal(content, &data)
-	if err != nil {
-		panic(err)
+	if err := json.Unmarshal(content, &data); err != nil {
+		return fmt.Errorf("Comment is not a valid ADF document: %v", err)
 	}
 	body := map[string]interface{}{
 		"body": data,
 	}
 
-	path := fmt.Sprintf("/rest/api/3/issue/%s/comment", issueNumber)
-	request, err := j.client.NewRequest(
+	path := j.restPath("/issue/%s/comment", issueNumber)
+	request, err := j.client.NewRequestWithContext(
+		ctx,
 		"POST",
 		path,
 		body,
@@ -225,35 +392,25 @@ func (j Jira) AddCommentCustom(issueNumber string, content []byte) error {
 	request.Header.Set("Content-Type", "application/json")
 	request.Header.Set("Accept", "application/json")
 
-	if err != nil {
-		panic(err)
-	}
 	response, err := j.client.Do(request, nil)
-	if err != nil {
-		return err
-	} else if response.StatusCode != 201 {
-		return fmt.Errorf(
-			"Response: \n\tstatus: %s\n\tmax results: %d\n\ttotal: %d\n",
-			response.Status,
-			response.MaxResults,
-			response.Total,
-		)
-	}
-	return nil
+	return apiError(response, err)
 }
 
-func (j Jira) UpdateCommentCustom(issueNumber, commentId string, content []byte) error {
+func (j Jira) UpdateCommentCustom(ctx context.Context, issueNumber, commentId string, content []byte) error {
+	if j.Config.Deployment == DeploymentServer {
+		return ErrNeedsCloud
+	}
 	data := make(map[string]interface{})
-	err := json.Unmarshal(content, &data)
-	if err != nil {
-		panic(err)
+	if err := json.Unmarshal(content, &data); err != nil {
+		return fmt.Errorf("Comment is not a valid ADF document: %v", err)
 	}
 	body := map[string]interface{}{
 		"body": data,
 	}
 
-	path := fmt.Sprintf("/rest/api/3/issue/%s/comment/%s", issueNumber, commentId)
-	request, err := j.client.NewRequest(
+	path := j.restPath("/issue/%s/comment/%s", issueNumber, commentId)
+	request, err := j.client.NewRequestWithContext(
+		ctx,
 		"PUT",
 		path,
 		body,
@@ -264,26 +421,74 @@ func (j Jira) UpdateCommentCustom(issueNumber, commentId string, content []byte)
 	request.Header.Set("Content-Type", "application/json")
 	request.Header.Set("Accept", "application/json")
 
+	response, err := j.client.Do(request, nil)
+	return apiError(response, err)
+}
+
+// UpdateComment replaces the body of a comment with wiki markup, the format of the v2 REST API
+func (j Jira) UpdateComment(ctx context.Context, issueNumber, commentId, content string) error {
+	_, response, err := j.client.Issue.UpdateCommentWithContext(ctx, issueNumber, &jira.Comment{
+		ID:   commentId,
+		Body: content,
+	})
+	return apiError(response, err)
+}
+
+// AddAttachment uploads a file as an attachment of an issue
+func (j Jira) AddAttachment(ctx context.Context, issueNumber, name string, content io.Reader) (*jira.Attachment, error) {
+	attachments, response, err := j.client.Issue.PostAttachmentWithContext(ctx, issueNumber, content, name)
 	if err != nil {
-		panic(err)
+		return nil, apiError(response, err)
 	}
-	response, err := j.client.Do(request, nil)
+	if attachments == nil || len(*attachments) == 0 {
+		return nil, errors.New("Jira did not return the created attachment")
+	}
+	return &(*attachments)[0], nil
+}
+
+var mediaFilePattern = regexp.MustCompile(`/file/([0-9a-fA-F-]+)/`)
+
+// GetAttachmentMediaID looks up the media file an attachment is stored as, which is what ADF media nodes refer to.
+// Jira only reveals it in the redirect to the attachment's content.
+func (j Jira) GetAttachmentMediaID(ctx context.Context, attachmentId string) (string, error) {
+	if j.Config.Deployment == DeploymentServer {
+		return "", ErrNeedsCloud
+	}
+	path := j.restPath("/attachment/content/%s", attachmentId)
+	request, err := j.client.NewRequestWithContext(
+		ctx,
+		"GET",
+		path,
+		nil,
+	)
 	if err != nil {
-		return err
-	} else if response.StatusCode != 200 {
-		return fmt.Errorf(
-			"Response: \n\tstatus: %s\n\tmax results: %d\n\ttotal: %d\n",
-			response.Status,
-			response.MaxResults,
-			response.Total,
-		)
+		return "", err
 	}
-	return nil
+
+	client := *j.httpClient
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 400 {
+		return "", newAPIError(response, nil)
+	}
+
+	match := mediaFilePattern.FindStringSubmatch(response.Header.Get("Location"))
+	if match == nil {
+		return "", fmt.Errorf("No media file found for attachment %s. Response status: %s", attachmentId, response.Status)
+	}
+	return match[1], nil
 }
 
-func (j Jira) DeleteComment(issueNumber, commentId string) error {
-	path := fmt.Sprintf("/rest/api/2/issue/%s/comment/%s", issueNumber, commentId)
-	request, err := j.client.NewRequest(
+func (j Jira) DeleteComment(ctx context.Context, issueNumber, commentId string) error {
+	path := j.restPath("/issue/%s/comment/%s", issueNumber, commentId)
+	request, err := j.client.NewRequestWithContext(
+		ctx,
 		"DELETE",
 		path,
 		nil,
@@ -293,11 +498,5 @@ func (j Jira) DeleteComment(issueNumber, commentId string) error {
 	}
 
 	response, err := j.client.Do(request, nil)
-	if err != nil {
-		fmt.Println(err)
-		return err
-	} else if response.StatusCode != 204 {
-		return errors.New("Failed to delete comment. Expected status 204.")
-	}
-	return nil
+	return apiError(response, err)
 }
@@ -0,0 +1,209 @@
+package jira
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/thaddeusrhatcher/jirate/auth"
+)
+
+const (
+	DefaultMaxRetries = 4
+	retryBaseDelay    = 500 * time.Millisecond
+	retryMaxDelay     = 30 * time.Second
+	retryAfterLimit   = 2 * time.Minute // Longer Retry-After waits are returned to the caller instead
+)
+
+// retryTransport sends requests through the rate limiter and retries those that failed for reasons that pass,
+// such as rate limiting and overloaded or restarting servers. It waits as long as Jira asks in Retry-After,
+// or backs off exponentially with jitter.
+type retryTransport struct {
+	base       http.RoundTripper
+	limiter    *rateLimiter // nil when unlimited
+	maxRetries int
+	sleep      func(ctx context.Context, d time.Duration) error
+}
+
+func newRetryTransport(base http.RoundTripper, limiter *rateLimiter, maxRetries int) *retryTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &retryTransport{base: base, limiter: limiter, maxRetries: maxRetries, sleep: sleep}
+}
+
+func (t *retryTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	ctx := request.Context()
+	for attempt := 0; ; attempt++ {
+		if t.limiter != nil {
+			if err := t.limiter.Wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+		response, err := t.base.RoundTrip(request)
+		if attempt >= t.maxRetries || !retryable(request, response, err) {
+			return response, err
+		}
+
+		delay := backoff(attempt)
+		if response != nil {
+			if wait, ok := retryAfter(response.Header.Get("Retry-After")); ok {
+				if wait > retryAfterLimit {
+					return response, nil
+				}
+				delay = wait
+			}
+			// Drain the body so the connection can be reused
+			io.Copy(io.Discard, io.LimitReader(response.Body, 1<<16))
+			response.Body.Close()
+		}
+		if request, err = rewind(request); err != nil {
+			return nil, err
+		}
+		if err = t.sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// retryable reports whether sending the request again may succeed and cannot apply its changes twice
+func retryable(request *http.Request, response *http.Response, err error) bool {
+	if request.Context().Err() != nil {
+		return false
+	}
+	if request.Body != nil && request.Body != http.NoBody && request.GetBody == nil {
+		return false
+	}
+	idempotent := request.Method != http.MethodPost && request.Method != http.MethodPatch
+	if errors.Is(err, auth.ErrLoginRequired) {
+		return false
+	}
+	if err != nil {
+		// The request may have reached Jira before the connection failed
+		return idempotent
+	}
+	switch response.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return idempotent
+	}
+	return false
+}
+
+// rewind returns a copy of the request with a fresh body, as the previous attempt consumed it
+func rewind(request *http.Request) (*http.Request, error) {
+	if request.GetBody == nil {
+		return request, nil
+	}
+	body, err := request.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	rewound := request.Clone(request.Context())
+	rewound.Body = body
+	return rewound, nil
+}
+
+// backoff doubles the delay with every attempt, picking it at random from the upper half to spread out clients
+func backoff(attempt int) time.Duration {
+	delay := retryBaseDelay << attempt
+	if delay > retryMaxDelay || delay <= 0 {
+		delay = retryMaxDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// retryAfter parses a Retry-After header, which holds either seconds or an HTTP date
+func retryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		wait := time.Until(date)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, false
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// rateLimiter is a token bucket: it holds up to burst tokens, refills rate tokens per second and every request
+// takes one, waiting for it when the bucket is empty
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+var (
+	limitersMu sync.Mutex
+	limiters   = map[string]*rateLimiter{}
+)
+
+// sharedLimiter returns the limiter of a site, so every client of the process talking to it shares one budget.
+// It returns nil when the rate is unlimited.
+func sharedLimiter(site string, rate float64, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+	limiter, ok := limiters[site]
+	if !ok || limiter.rate != rate || limiter.burst != float64(burst) {
+		limiter = &rateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+		limiters[site] = limiter
+	}
+	return limiter
+}
+
+// Wait takes a token, waiting until one is available or the context is done
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens--
+	// A negative balance reserves a token that is refilled in the future
+	wait := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	if err := sleep(ctx, wait); err != nil {
+		l.mu.Lock()
+		l.tokens++ // Give back the reservation
+		l.mu.Unlock()
+		return err
+	}
+	return nil
+}
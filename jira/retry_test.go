@@ -0,0 +1,291 @@
+package jira
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thaddeusrhatcher/jirate/auth"
+	"github.com/thaddeusrhatcher/jirate/config"
+)
+
+// flakySite answers requests with the statuses in turn, repeating the last one, and remembers the bodies it got
+type flakySite struct {
+	mu         sync.Mutex
+	statuses   []int
+	retryAfter string
+	bodies     []string
+}
+
+func (s *flakySite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	status := s.statuses[min(len(s.bodies), len(s.statuses)-1)]
+	s.bodies = append(s.bodies, string(body))
+	s.mu.Unlock()
+
+	if s.retryAfter != "" {
+		w.Header().Set("Retry-After", s.retryAfter)
+	}
+	w.WriteHeader(status)
+}
+
+func (s *flakySite) requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.bodies)
+}
+
+// testTransport is a retry transport that records how long it would have slept instead of sleeping
+func testTransport(maxRetries int) (*retryTransport, *[]time.Duration) {
+	var delays []time.Duration
+	transport := newRetryTransport(nil, nil, maxRetries)
+	transport.sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return ctx.Err()
+	}
+	return transport, &delays
+}
+
+func send(t *testing.T, ctx context.Context, transport http.RoundTripper, method, url, body string) *http.Response {
+	t.Helper()
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := transport.RoundTrip(request)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	response.Body.Close()
+	return response
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name       string
+		retryAfter string
+		status     int             // The status the caller gets
+		requests   int             // Requests that reach the site
+		delays     []time.Duration // Waits between them
+	}{
+		{name: "seconds", retryAfter: "7", status: http.StatusOK, requests: 2, delays: []time.Duration{7 * time.Second}},
+		{name: "zero", retryAfter: "0", status: http.StatusOK, requests: 2, delays: []time.Duration{0}},
+		{name: "past date", retryAfter: "Mon, 02 Jan 2006 15:04:05 GMT", status: http.StatusOK, requests: 2,
+			delays: []time.Duration{0}},
+		{name: "longer than the limit", retryAfter: "600", status: http.StatusTooManyRequests, requests: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			site := &flakySite{statuses: []int{http.StatusTooManyRequests, http.StatusOK}, retryAfter: test.retryAfter}
+			server := httptest.NewServer(site)
+			defer server.Close()
+
+			transport, delays := testTransport(DefaultMaxRetries)
+			response := send(t, context.Background(), transport, http.MethodGet, server.URL, "")
+			if response.StatusCode != test.status {
+				t.Errorf("Status %d, want %d", response.StatusCode, test.status)
+			}
+			if site.requests() != test.requests {
+				t.Errorf("%d requests, want %d", site.requests(), test.requests)
+			}
+			if len(*delays) != len(test.delays) {
+				t.Fatalf("Waited %v, want %v", *delays, test.delays)
+			}
+			for i, delay := range *delays {
+				if delay != test.delays[i] {
+					t.Errorf("Waited %v, want %v", *delays, test.delays)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryMethods(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		status   int
+		requests int
+	}{
+		{name: "GET on 503", method: http.MethodGet, status: http.StatusServiceUnavailable, requests: 3},
+		{name: "POST on 503", method: http.MethodPost, status: http.StatusServiceUnavailable, requests: 1},
+		{name: "PATCH on 502", method: http.MethodPatch, status: http.StatusBadGateway, requests: 1},
+		{name: "POST on 429", method: http.MethodPost, status: http.StatusTooManyRequests, requests: 3},
+		{name: "GET on 500", method: http.MethodGet, status: http.StatusInternalServerError, requests: 1},
+		{name: "GET on 404", method: http.MethodGet, status: http.StatusNotFound, requests: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			site := &flakySite{statuses: []int{test.status}}
+			server := httptest.NewServer(site)
+			defer server.Close()
+
+			transport, _ := testTransport(2)
+			body := ""
+			if test.method != http.MethodGet {
+				body = `{"jql":"project = OPS"}`
+			}
+			response := send(t, context.Background(), transport, test.method, server.URL, body)
+			if response.StatusCode != test.status {
+				t.Errorf("Status %d, want %d", response.StatusCode, test.status)
+			}
+			if site.requests() != test.requests {
+				t.Errorf("%d requests, want %d", site.requests(), test.requests)
+			}
+			for i, got := range site.bodies {
+				if got != body {
+					t.Errorf("Request %d sent body %q, want %q", i+1, got, body)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryAttemptLimit(t *testing.T) {
+	for _, maxRetries := range []int{0, 1, 4} {
+		site := &flakySite{statuses: []int{http.StatusBadGateway}}
+		server := httptest.NewServer(site)
+
+		transport, delays := testTransport(maxRetries)
+		response := send(t, context.Background(), transport, http.MethodGet, server.URL, "")
+		server.Close()
+		if response.StatusCode != http.StatusBadGateway {
+			t.Errorf("%d retries: status %d, want %d", maxRetries, response.StatusCode, http.StatusBadGateway)
+		}
+		if site.requests() != maxRetries+1 {
+			t.Errorf("%d retries: %d requests, want %d", maxRetries, site.requests(), maxRetries+1)
+		}
+		for attempt, delay := range *delays {
+			if limit := retryBaseDelay << attempt; delay < limit/2 || delay > limit {
+				t.Errorf("%d retries: waited %v before retry %d, want between %v and %v",
+					maxRetries, delay, attempt+1, limit/2, limit)
+			}
+		}
+	}
+}
+
+func TestRetryStopsWhenCancelled(t *testing.T) {
+	site := &flakySite{statuses: []int{http.StatusServiceUnavailable}}
+	server := httptest.NewServer(site)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	transport := newRetryTransport(nil, nil, DefaultMaxRetries)
+	transport.sleep = func(ctx context.Context, d time.Duration) error {
+		cancel()
+		return ctx.Err()
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transport.RoundTrip(request); !errors.Is(err, context.Canceled) {
+		t.Errorf("RoundTrip returned %v, want %v", err, context.Canceled)
+	}
+	if site.requests() != 1 {
+		t.Errorf("%d requests, want 1", site.requests())
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt := 0; attempt < 70; attempt++ {
+		limit := retryBaseDelay << attempt
+		if limit > retryMaxDelay || limit <= 0 {
+			limit = retryMaxDelay
+		}
+		for i := 0; i < 100; i++ {
+			if delay := backoff(attempt); delay < limit/2 || delay > limit {
+				t.Fatalf("backoff(%d) = %v, want between %v and %v", attempt, delay, limit/2, limit)
+			}
+		}
+	}
+}
+
+func TestSharedLimiter(t *testing.T) {
+	server := httptest.NewServer(&flakySite{statuses: []int{http.StatusOK}})
+	defer server.Close()
+	other := httptest.NewServer(&flakySite{statuses: []int{http.StatusOK}})
+	defer other.Close()
+
+	profile := func(url string) *config.Profile {
+		return &config.Profile{Name: "limited", Url: url, Deployment: "cloud", RateLimit: 1, RateBurst: 2}
+	}
+	connectTo := func(url string) Jira {
+		j, err := NewClientForProfile(context.Background(), profile(url))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return j
+	}
+	get := func(j Jira, timeout time.Duration) error {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, j.Config.Url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, err := j.httpClient.Do(request)
+		if err == nil {
+			response.Body.Close()
+		}
+		return err
+	}
+
+	first, second := connectTo(server.URL), connectTo(server.URL)
+	if first.httpClient.Transport.(*retryTransport).limiter != second.httpClient.Transport.(*retryTransport).limiter {
+		t.Fatal("Clients of one profile have limiters of their own")
+	}
+
+	// The first client uses up the burst, so the second has to wait a second for the next token
+	for i := 0; i < 2; i++ {
+		if err := get(first, time.Second); err != nil {
+			t.Fatalf("Request %d within the burst: %v", i+1, err)
+		}
+	}
+	if err := get(second, 50*time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Request past the burst returned %v, want it to wait for the limiter", err)
+	}
+	// Other sites have a budget of their own
+	if err := get(connectTo(other.URL), 50*time.Millisecond); err != nil {
+		t.Errorf("Request to another site: %v", err)
+	}
+}
+
+// roundTripFunc sends requests with a function
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(request *http.Request) (*http.Response, error) {
+	return f(request)
+}
+
+func TestRetryLoginRequired(t *testing.T) {
+	attempts := 0
+	transport, delays := testTransport(DefaultMaxRetries)
+	transport.base = roundTripFunc(func(*http.Request) (*http.Response, error) {
+		attempts++
+		return nil, auth.ErrLoginRequired
+	})
+	request, err := http.NewRequest(http.MethodGet, "https://example.atlassian.net/rest/api/3/myself", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transport.RoundTrip(request); !errors.Is(err, auth.ErrLoginRequired) {
+		t.Errorf("RoundTrip returned %v, want %v", err, auth.ErrLoginRequired)
+	}
+	if attempts != 1 || len(*delays) != 0 {
+		t.Errorf("Sent %d requests and waited %v, want a single attempt", attempts, *delays)
+	}
+}
@@ -0,0 +1,596 @@
+package jiratest
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/andygrunwald/go-jira"
+	myJira "github.com/thaddeusrhatcher/jirate/jira"
+)
+
+const (
+	jiraTimeLayout     = "2006-01-02T15:04:05.000-0700"
+	renderedTimeLayout = "02/Jan/06 3:04 PM"
+)
+
+func (s *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /rest/api/{version}/serverInfo", s.api(s.serverInfo, false))
+	mux.HandleFunc("GET /rest/api/{version}/myself", s.api(s.myself, true))
+	mux.HandleFunc("GET /rest/api/{version}/user/search", s.api(s.findUsers, true))
+	mux.HandleFunc("GET /rest/api/{version}/search", s.api(s.search, true))
+	mux.HandleFunc("GET /rest/api/{version}/issue/{key}", s.api(s.getIssue, true))
+	mux.HandleFunc("GET /rest/api/{version}/issue/{key}/comment", s.api(s.listComments, true))
+	mux.HandleFunc("POST /rest/api/{version}/issue/{key}/comment", s.api(s.addComment, true))
+	mux.HandleFunc("GET /rest/api/{version}/issue/{key}/comment/{id}", s.api(s.getComment, true))
+	mux.HandleFunc("PUT /rest/api/{version}/issue/{key}/comment/{id}", s.api(s.updateComment, true))
+	mux.HandleFunc("DELETE /rest/api/{version}/issue/{key}/comment/{id}", s.api(s.deleteComment, true))
+	mux.HandleFunc("GET /rest/api/{version}/issue/{key}/transitions", s.api(s.getTransitions, true))
+	mux.HandleFunc("POST /rest/api/{version}/issue/{key}/transitions", s.api(s.doTransition, true))
+	mux.HandleFunc("POST /rest/api/{version}/issue/{key}/attachments", s.api(s.addAttachments, true))
+	mux.HandleFunc("GET /rest/api/{version}/attachment/content/{id}", s.api(s.attachmentContent, true))
+	// Jira Cloud redirects attachment content to its media store
+	mux.HandleFunc("GET /file/{media}/binary", s.mediaContent)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("The fake Jira has no %s %s", r.Method, r.URL.Path))
+	})
+	return mux
+}
+
+// api checks the REST API version and the credentials of a request before handling it.
+// Version 3 only exists on Jira Cloud.
+func (s *Server) api(handler http.HandlerFunc, authenticated bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch version := r.PathValue("version"); {
+		case version == "2":
+		case version == "3" && s.Deployment != myJira.DeploymentServer:
+		default:
+			writeError(w, http.StatusNotFound, fmt.Sprintf("null for uri: %s", r.URL))
+			return
+		}
+		if authenticated {
+			if username, password, ok := r.BasicAuth(); !ok || username != Username || password != Password {
+				writeError(w, http.StatusUnauthorized, "You are not authenticated. Authentication required to perform this operation.")
+				return
+			}
+		}
+		handler(w, r)
+	}
+}
+
+func (s *Server) serverInfo(w http.ResponseWriter, r *http.Request) {
+	info := myJira.ServerInfo{
+		BaseUrl:        s.URL,
+		Version:        "1001.0.0-SNAPSHOT",
+		VersionNumbers: []int{1001, 0, 0},
+		DeploymentType: "Cloud",
+		ServerTitle:    "Jira",
+	}
+	if s.Deployment == myJira.DeploymentServer {
+		info.Version = "9.12.2"
+		info.VersionNumbers = []int{9, 12, 2}
+		info.DeploymentType = "Server"
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+func (s *Server) myself(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.User)
+}
+
+// findUsers matches the query against names and email addresses. Jira Cloud searches by query,
+// Jira Server by username.
+func (s *Server) findUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("query")
+	if query == "" {
+		query = r.URL.Query().Get("username")
+	}
+	if query == "" {
+		writeError(w, http.StatusBadRequest, "The query parameter is required")
+		return
+	}
+	query = strings.ToLower(query)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := []jira.User{}
+	for _, user := range s.users {
+		if strings.Contains(strings.ToLower(user.DisplayName), query) ||
+			strings.Contains(strings.ToLower(user.EmailAddress), query) ||
+			strings.Contains(strings.ToLower(user.Name), query) {
+			users = append(users, user)
+		}
+	}
+	writeJSON(w, http.StatusOK, users)
+}
+
+var andPattern = regexp.MustCompile(`(?i)\s+AND\s+`)
+
+// search understands JQL made of field = value clauses joined by AND, on the assignee, status, project and key
+func (s *Server) search(w http.ResponseWriter, r *http.Request) {
+	jql := strings.TrimSpace(r.URL.Query().Get("jql"))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var clauses []func(*Issue) bool
+	if jql != "" {
+		for _, clause := range andPattern.Split(jql, -1) {
+			field, value, ok := strings.Cut(clause, "=")
+			if !ok {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("The fake Jira does not understand the JQL clause %q", clause))
+				return
+			}
+			field = strings.ToLower(strings.TrimSpace(field))
+			value = strings.Trim(strings.TrimSpace(value), `"'`)
+			switch field {
+			case "assignee":
+				user := jira.User{Name: value, AccountID: value}
+				if strings.EqualFold(value, "currentUser()") {
+					user = s.User
+				}
+				clauses = append(clauses, func(issue *Issue) bool {
+					return issue.Assignee != nil && (issue.Assignee.Name != "" && issue.Assignee.Name == user.Name ||
+						issue.Assignee.AccountID != "" && issue.Assignee.AccountID == user.AccountID)
+				})
+			case "status":
+				clauses = append(clauses, func(issue *Issue) bool { return strings.EqualFold(issue.Status, value) })
+			case "project":
+				clauses = append(clauses, func(issue *Issue) bool {
+					return strings.HasPrefix(strings.ToUpper(issue.Key), strings.ToUpper(value)+"-")
+				})
+			case "key", "issuekey":
+				clauses = append(clauses, func(issue *Issue) bool { return strings.EqualFold(issue.Key, value) })
+			default:
+				writeFieldError(w, http.StatusBadRequest, "jql", fmt.Sprintf("Field '%s' is not supported by the fake Jira", field))
+				return
+			}
+		}
+	}
+
+	issues := []map[string]any{}
+issues:
+	for _, issue := range s.sortedIssues() {
+		for _, matches := range clauses {
+			if !matches(issue) {
+				continue issues
+			}
+		}
+		issues = append(issues, s.issueJSON(issue, r.PathValue("version"), false))
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"startAt":    0,
+		"maxResults": 50,
+		"total":      len(issues),
+		"issues":     issues,
+	})
+}
+
+func (s *Server) getIssue(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	issue, ok := s.findIssue(w, r)
+	if !ok {
+		return
+	}
+	rendered := strings.Contains(r.URL.Query().Get("expand"), "renderedFields")
+	writeJSON(w, http.StatusOK, s.issueJSON(issue, r.PathValue("version"), rendered))
+}
+
+func (s *Server) listComments(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	issue, ok := s.findIssue(w, r)
+	if !ok {
+		return
+	}
+	rendered := strings.Contains(r.URL.Query().Get("expand"), "renderedBody")
+	comments := []map[string]any{}
+	for _, comment := range issue.Comments {
+		comments = append(comments, s.commentJSON(issue, comment, r.PathValue("version"), rendered))
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"startAt":    0,
+		"maxResults": len(comments),
+		"total":      len(comments),
+		"comments":   comments,
+	})
+}
+
+func (s *Server) addComment(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	issue, ok := s.findIssue(w, r)
+	if !ok {
+		return
+	}
+	comment := Comment{ID: s.newID(), Author: s.User, Created: time.Now()}
+	comment.Updated = comment.Created
+	if !readCommentBody(w, r, &comment) {
+		return
+	}
+	issue.Comments = append(issue.Comments, comment)
+	issue.Updated = comment.Updated
+	writeJSON(w, http.StatusCreated, s.commentJSON(issue, comment, r.PathValue("version"), false))
+}
+
+func (s *Server) getComment(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	issue, index, ok := s.findComment(w, r)
+	if !ok {
+		return
+	}
+	rendered := strings.Contains(r.URL.Query().Get("expand"), "renderedBody")
+	writeJSON(w, http.StatusOK, s.commentJSON(issue, issue.Comments[index], r.PathValue("version"), rendered))
+}
+
+func (s *Server) updateComment(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	issue, index, ok := s.findComment(w, r)
+	if !ok {
+		return
+	}
+	comment := issue.Comments[index]
+	if !readCommentBody(w, r, &comment) {
+		return
+	}
+	comment.Updated = time.Now()
+	issue.Comments[index] = comment
+	issue.Updated = comment.Updated
+	writeJSON(w, http.StatusOK, s.commentJSON(issue, comment, r.PathValue("version"), false))
+}
+
+func (s *Server) deleteComment(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	issue, index, ok := s.findComment(w, r)
+	if !ok {
+		return
+	}
+	issue.Comments = append(issue.Comments[:index], issue.Comments[index+1:]...)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) getTransitions(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	issue, ok := s.findIssue(w, r)
+	if !ok {
+		return
+	}
+	transitions := []map[string]any{}
+	for _, transition := range issue.Transitions {
+		transitions = append(transitions, map[string]any{
+			"id":     transition.ID,
+			"name":   transition.Name,
+			"to":     map[string]any{"name": transition.To},
+			"fields": map[string]any{},
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"expand": "transitions", "transitions": transitions})
+}
+
+func (s *Server) doTransition(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	issue, ok := s.findIssue(w, r)
+	if !ok {
+		return
+	}
+	var request struct {
+		Transition struct {
+			ID string `json:"id"`
+		} `json:"transition"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request: %v", err))
+		return
+	}
+	for _, transition := range issue.Transitions {
+		if transition.ID == request.Transition.ID {
+			issue.Status = transition.To
+			issue.Updated = time.Now()
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	writeError(w, http.StatusBadRequest, fmt.Sprintf("Transition id '%s' is not valid for this issue.", request.Transition.ID))
+}
+
+func (s *Server) addAttachments(w http.ResponseWriter, r *http.Request) {
+	// Jira takes either spelling
+	if token := r.Header.Get("X-Atlassian-Token"); token != "no-check" && token != "nocheck" {
+		writeError(w, http.StatusForbidden, "XSRF check failed")
+		return
+	}
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid multipart request: %v", err))
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	issue, ok := s.findIssue(w, r)
+	if !ok {
+		return
+	}
+
+	attachments := []map[string]any{}
+	for _, header := range r.MultipartForm.File["file"] {
+		file, err := header.Open()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		content, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		attachment := Attachment{
+			ID:       s.newID(),
+			Filename: header.Filename,
+			MimeType: http.DetectContentType(content),
+			Content:  content,
+		}
+		if s.Deployment != myJira.DeploymentServer {
+			attachment.MediaID = newMediaID()
+		}
+		issue.Attachments = append(issue.Attachments, attachment)
+		attachments = append(attachments, s.attachmentJSON(attachment))
+	}
+	if len(attachments) == 0 {
+		writeError(w, http.StatusBadRequest, "No file was attached")
+		return
+	}
+	writeJSON(w, http.StatusOK, attachments)
+}
+
+// attachmentContent serves an attachment. Jira Cloud redirects to the media file, which is how jirate finds
+// the media ID of an attachment.
+func (s *Server) attachmentContent(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attachment := s.findAttachment(func(a *Attachment) bool { return a.ID == r.PathValue("id") })
+	if attachment == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("The attachment with id '%s' does not exist", r.PathValue("id")))
+		return
+	}
+	if attachment.MediaID != "" {
+		http.Redirect(w, r, fmt.Sprintf("%s/file/%s/binary?name=%s", s.URL, attachment.MediaID, attachment.Filename),
+			http.StatusSeeOther)
+		return
+	}
+	w.Header().Set("Content-Type", attachment.MimeType)
+	w.Write(attachment.Content)
+}
+
+func (s *Server) mediaContent(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attachment := s.findAttachment(func(a *Attachment) bool { return a.MediaID == r.PathValue("media") })
+	if attachment == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", attachment.MimeType)
+	w.Write(attachment.Content)
+}
+
+// findIssue looks up the issue of the request, answering 404 when there is none. The caller holds the lock.
+func (s *Server) findIssue(w http.ResponseWriter, r *http.Request) (*Issue, bool) {
+	issue, ok := s.issues[r.PathValue("key")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Issue does not exist or you do not have permission to see it.")
+	}
+	return issue, ok
+}
+
+// findComment looks up the comment of the request, answering 404 when there is none. The caller holds the lock.
+func (s *Server) findComment(w http.ResponseWriter, r *http.Request) (*Issue, int, bool) {
+	issue, ok := s.findIssue(w, r)
+	if !ok {
+		return nil, 0, false
+	}
+	for i, comment := range issue.Comments {
+		if comment.ID == r.PathValue("id") {
+			return issue, i, true
+		}
+	}
+	writeError(w, http.StatusNotFound, fmt.Sprintf("Can not find a comment for the id: %s.", r.PathValue("id")))
+	return nil, 0, false
+}
+
+// findAttachment returns the first attachment of any issue that matches, the caller holds the lock
+func (s *Server) findAttachment(matches func(*Attachment) bool) *Attachment {
+	for _, issue := range s.issues {
+		for i := range issue.Attachments {
+			if matches(&issue.Attachments[i]) {
+				return &issue.Attachments[i]
+			}
+		}
+	}
+	return nil
+}
+
+// readCommentBody sets the body of a comment from the request: wiki markup in version 2 of the REST API,
+// an ADF document in version 3. It answers 400 and returns false when the body is not valid.
+func readCommentBody(w http.ResponseWriter, r *http.Request, comment *Comment) bool {
+	var request struct {
+		Body json.RawMessage `json:"body"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request: %v", err))
+		return false
+	}
+
+	if r.PathValue("version") == "3" {
+		var document struct {
+			Type    string `json:"type"`
+			Version int    `json:"version"`
+		}
+		if json.Unmarshal(request.Body, &document) != nil || document.Type != "doc" || document.Version != 1 {
+			writeFieldError(w, http.StatusBadRequest, "comment", "Comment body is not valid!")
+			return false
+		}
+		comment.Body = ""
+		comment.Document = request.Body
+		return true
+	}
+
+	var body string
+	if json.Unmarshal(request.Body, &body) != nil {
+		writeFieldError(w, http.StatusBadRequest, "body", "Comment body is not valid!")
+		return false
+	}
+	if strings.TrimSpace(body) == "" {
+		writeFieldError(w, http.StatusBadRequest, "body", "Comment body can not be empty!")
+		return false
+	}
+	comment.Body = body
+	comment.Document = nil
+	return true
+}
+
+// issueJSON describes an issue the way the REST API version does, the caller holds the lock
+func (s *Server) issueJSON(issue *Issue, version string, rendered bool) map[string]any {
+	comments := []map[string]any{}
+	for _, comment := range issue.Comments {
+		comments = append(comments, s.commentJSON(issue, comment, version, false))
+	}
+	attachments := []map[string]any{}
+	for _, attachment := range issue.Attachments {
+		attachments = append(attachments, s.attachmentJSON(attachment))
+	}
+	var description any = issue.Description
+	if version == "3" {
+		description = document(issue.Description)
+	}
+
+	result := map[string]any{
+		"key":  issue.Key,
+		"self": fmt.Sprintf("%s/rest/api/%s/issue/%s", s.URL, version, issue.Key),
+		"fields": map[string]any{
+			"summary":     issue.Summary,
+			"description": description,
+			"status":      map[string]any{"name": issue.Status},
+			"creator":     issue.Creator,
+			"reporter":    issue.Creator,
+			"assignee":    issue.Assignee,
+			"created":     issue.Created.Format(jiraTimeLayout),
+			"updated":     issue.Updated.Format(jiraTimeLayout),
+			"comment":     map[string]any{"comments": comments, "total": len(comments)},
+			"attachment":  attachments,
+		},
+	}
+	if rendered {
+		renderedComments := []map[string]any{}
+		for _, comment := range issue.Comments {
+			renderedComment := s.commentJSON(issue, comment, version, false)
+			renderedComment["body"] = renderHTML(comment.Text())
+			renderedComment["created"] = comment.Created.Format(renderedTimeLayout)
+			renderedComment["updated"] = comment.Updated.Format(renderedTimeLayout)
+			renderedComments = append(renderedComments, renderedComment)
+		}
+		result["renderedFields"] = map[string]any{
+			"description": renderHTML(issue.Description),
+			"created":     issue.Created.Format(renderedTimeLayout),
+			"updated":     issue.Updated.Format(renderedTimeLayout),
+			"comment":     map[string]any{"comments": renderedComments, "total": len(renderedComments)},
+		}
+	}
+	for _, field := range issue.Omit {
+		delete(result["fields"].(map[string]any), field)
+		delete(result, field)
+	}
+	return result
+}
+
+// commentJSON describes a comment the way the REST API version does. Version 3 turns wiki markup into a document,
+// version 2 turns documents into plain text.
+func (s *Server) commentJSON(issue *Issue, comment Comment, version string, rendered bool) map[string]any {
+	var body any = comment.Text()
+	if version == "3" {
+		if comment.Document != nil {
+			body = comment.Document
+		} else {
+			body = document(comment.Body)
+		}
+	}
+	result := map[string]any{
+		"id":           comment.ID,
+		"self":         fmt.Sprintf("%s/rest/api/%s/issue/%s/comment/%s", s.URL, version, issue.Key, comment.ID),
+		"author":       comment.Author,
+		"updateAuthor": comment.Author,
+		"body":         body,
+		"created":      comment.Created.Format(jiraTimeLayout),
+		"updated":      comment.Updated.Format(jiraTimeLayout),
+	}
+	if rendered {
+		result["renderedBody"] = renderHTML(comment.Text())
+	}
+	return result
+}
+
+func (s *Server) attachmentJSON(attachment Attachment) map[string]any {
+	author := s.User
+	return map[string]any{
+		"id":       attachment.ID,
+		"self":     fmt.Sprintf("%s/rest/api/2/attachment/%s", s.URL, attachment.ID),
+		"filename": attachment.Filename,
+		"author":   &author,
+		"size":     len(attachment.Content),
+		"mimeType": attachment.MimeType,
+		"content":  fmt.Sprintf("%s/rest/api/2/attachment/content/%s", s.URL, attachment.ID),
+	}
+}
+
+// document wraps text in an ADF document, a paragraph per line
+func document(text string) map[string]any {
+	paragraphs := []map[string]any{}
+	if text != "" {
+		for _, line := range strings.Split(text, "\n") {
+			paragraph := map[string]any{"type": "paragraph", "content": []map[string]any{}}
+			if line != "" {
+				paragraph["content"] = []map[string]any{{"type": "text", "text": line}}
+			}
+			paragraphs = append(paragraphs, paragraph)
+		}
+	}
+	return map[string]any{"type": "doc", "version": 1, "content": paragraphs}
+}
+
+// renderHTML is the fake's stand-in for Jira's renderer: a paragraph per line of escaped text
+func renderHTML(text string) string {
+	if text == "" {
+		return ""
+	}
+	var rendered strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		rendered.WriteString("<p>" + html.EscapeString(line) + "</p>")
+	}
+	return rendered.String()
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError answers with the errorMessages Jira describes failed requests with
+func writeError(w http.ResponseWriter, status int, messages ...string) {
+	writeJSON(w, status, map[string]any{"errorMessages": messages, "errors": map[string]string{}})
+}
+
+// writeFieldError answers with a problem with one field of the request
+func writeFieldError(w http.ResponseWriter, status int, field, message string) {
+	writeJSON(w, status, map[string]any{"errorMessages": []string{}, "errors": map[string]string{field: message}})
+}
@@ -0,0 +1,260 @@
+// Package jiratest runs a fake Jira site in the process, for testing code that talks to Jira without a real site.
+//
+// The fake keeps issues, comments, users, transitions and attachments in memory and answers the parts of the
+// v2 and v3 REST APIs jirate uses, in the shapes of Jira Cloud or of Jira Server and Data Center:
+//
+//	server := jiratest.NewServer(jira.DeploymentCloud)
+//	defer server.Close()
+//	server.AddIssue(jiratest.Issue{Key: "OPS-1", Summary: "Rotate the certificates"})
+//	client, err := server.Client(ctx)
+package jiratest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andygrunwald/go-jira"
+	"github.com/thaddeusrhatcher/jirate/config"
+	myJira "github.com/thaddeusrhatcher/jirate/jira"
+)
+
+// Credentials the fake accepts, as username and API token
+const (
+	Username = "tester"
+	Password = "secret"
+)
+
+// Issue is an issue of the fake site. Fields left empty are filled in when it is added.
+type Issue struct {
+	Key         string
+	Summary     string
+	Description string // Wiki markup
+	Status      string // Name of the status, To Do when empty
+	Assignee    *jira.User
+	Creator     *jira.User // The user of the client when empty
+	Created     time.Time
+	Updated     time.Time
+	Comments    []Comment
+	Transitions []Transition // The To Do, In Progress and Done workflow when empty
+	Attachments []Attachment
+	Omit        []string // Fields left out of responses, as Jira does for fields hidden from the user, e.g. status
+}
+
+// Comment is a comment of an issue. Comments posted through version 3 of the REST API hold an ADF document,
+// the others wiki markup.
+type Comment struct {
+	ID       string
+	Author   jira.User
+	Body     string          // Wiki markup
+	Document json.RawMessage // ADF document
+	Created  time.Time
+	Updated  time.Time
+}
+
+// Text is the body of the comment as plain text, whichever format it was posted in
+func (c Comment) Text() string {
+	if c.Document == nil {
+		return c.Body
+	}
+	var document any
+	if err := json.Unmarshal(c.Document, &document); err != nil {
+		return ""
+	}
+	return strings.TrimSpace(plainText(document))
+}
+
+// Transition moves an issue to another status
+type Transition struct {
+	ID   string
+	Name string
+	To   string // Name of the status the issue ends up in
+}
+
+// Attachment is a file attached to an issue
+type Attachment struct {
+	ID       string
+	Filename string
+	MimeType string
+	Content  []byte
+	MediaID  string // The media file ADF documents refer to the attachment by, on Jira Cloud
+}
+
+// DefaultTransitions is the workflow of issues added without transitions of their own
+var DefaultTransitions = []Transition{
+	{ID: "11", Name: "To Do", To: "To Do"},
+	{ID: "21", Name: "In Progress", To: "In Progress"},
+	{ID: "31", Name: "Done", To: "Done"},
+}
+
+// Server is a fake Jira site listening on a local port. Its methods are safe to use while clients send requests.
+type Server struct {
+	*httptest.Server
+	Deployment myJira.Deployment
+	User       jira.User // The account the credentials belong to
+
+	mu     sync.Mutex
+	users  []jira.User
+	issues map[string]*Issue
+	nextID int
+}
+
+// NewServer starts a fake site of the deployment. Close it when done.
+func NewServer(deployment myJira.Deployment) *Server {
+	s := &Server{
+		Deployment: deployment,
+		User: jira.User{
+			AccountID:    "5b10ac8d82e05b22cc7d4ef5",
+			Name:         Username,
+			Key:          Username,
+			DisplayName:  "Test User",
+			EmailAddress: "tester@example.com",
+			Active:       true,
+		},
+		issues: map[string]*Issue{},
+		nextID: 10000,
+	}
+	if deployment == myJira.DeploymentServer {
+		// Jira Server has no account IDs
+		s.User.AccountID = ""
+	}
+	s.users = []jira.User{s.User}
+	s.Server = httptest.NewServer(s.routes())
+	return s
+}
+
+// Profile is a config profile logging in to the fake site. Its deployment is left to be detected.
+func (s *Server) Profile() *config.Profile {
+	maxRetries := 0
+	return &config.Profile{
+		Name:       "jiratest",
+		Url:        s.URL,
+		Username:   Username,
+		Password:   Password,
+		MaxRetries: &maxRetries,
+	}
+}
+
+// Client connects a Jira client to the fake site
+func (s *Server) Client(ctx context.Context) (myJira.Jira, error) {
+	return myJira.NewClientForProfile(ctx, s.Profile())
+}
+
+// AddUser adds users that mentions and user searches can find
+func (s *Server) AddUser(users ...jira.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users = append(s.users, users...)
+}
+
+// AddIssue adds an issue, replacing any with the same key
+func (s *Server) AddIssue(issue Issue) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if issue.Status == "" {
+		issue.Status = "To Do"
+	}
+	if issue.Creator == nil {
+		creator := s.User
+		issue.Creator = &creator
+	}
+	if issue.Created.IsZero() {
+		issue.Created = time.Now()
+	}
+	if issue.Updated.IsZero() {
+		issue.Updated = issue.Created
+	}
+	if issue.Transitions == nil {
+		issue.Transitions = DefaultTransitions
+	}
+	issue.Comments = append([]Comment(nil), issue.Comments...)
+	for i := range issue.Comments {
+		if issue.Comments[i].ID == "" {
+			issue.Comments[i].ID = s.newID()
+		}
+	}
+	issue.Transitions = append([]Transition(nil), issue.Transitions...)
+	issue.Attachments = append([]Attachment(nil), issue.Attachments...)
+	s.issues[issue.Key] = &issue
+}
+
+// Issue returns a copy of an issue, as it is now
+func (s *Server) Issue(key string) (Issue, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	issue, ok := s.issues[key]
+	if !ok {
+		return Issue{}, false
+	}
+	copied := *issue
+	copied.Comments = append([]Comment(nil), issue.Comments...)
+	copied.Transitions = append([]Transition(nil), issue.Transitions...)
+	copied.Attachments = append([]Attachment(nil), issue.Attachments...)
+	return copied, true
+}
+
+// Comments returns the comments of an issue, oldest first
+func (s *Server) Comments(key string) []Comment {
+	issue, _ := s.Issue(key)
+	return issue.Comments
+}
+
+// newID numbers comments and attachments, the caller holds the lock
+func (s *Server) newID() string {
+	s.nextID++
+	return strconv.Itoa(s.nextID)
+}
+
+// sortedIssues returns the issues ordered by key, the caller holds the lock
+func (s *Server) sortedIssues() []*Issue {
+	issues := make([]*Issue, 0, len(s.issues))
+	for _, issue := range s.issues {
+		issues = append(issues, issue)
+	}
+	sort.Slice(issues, func(i, j int) bool { return issues[i].Key < issues[j].Key })
+	return issues
+}
+
+// newMediaID makes up the UUID of a media file
+func newMediaID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// plainText collects the text of an ADF node, putting blocks on lines of their own
+func plainText(node any) string {
+	switch node := node.(type) {
+	case map[string]any:
+		if text, ok := node["text"].(string); ok {
+			return text
+		}
+		content := plainText(node["content"])
+		switch node["type"] {
+		case "paragraph", "heading", "codeBlock", "listItem":
+			return content + "\n"
+		case "hardBreak":
+			return "\n"
+		case "mention":
+			if attrs, ok := node["attrs"].(map[string]any); ok {
+				text, _ := attrs["text"].(string)
+				return text
+			}
+		}
+		return content
+	case []any:
+		var text strings.Builder
+		for _, child := range node {
+			text.WriteString(plainText(child))
+		}
+		return text.String()
+	}
+	return ""
+}
@@ -1,15 +1,28 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"os"
+	"os/signal"
 
 	"github.com/thaddeusrhatcher/jirate/cmd"
 )
 
 
 func main() {
+	// Ctrl+C cancels the requests in flight, a second one kills jirate outright
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
 	rootCmd := cmd.NewRoot()
-	if err := rootCmd.Execute(); err != nil {
-		os.Exit(1)
+	err := rootCmd.ExecuteContext(ctx)
+	stop()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(cmd.ExitCode(err))
 	}
 }
@@ -1,10 +1,13 @@
 package processor
 
 import (
-	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	md "github.com/JohannesKaufmann/html-to-markdown"
 	"github.com/andygrunwald/go-jira"
@@ -43,11 +46,6 @@ const (
 	ActionUpdate Action = "update"
 )
 
-type Processor interface {
-	Process() error
-	Render() error
-}
-
 type issueStyles struct {
 	container lipgloss.Style
 	status    lipgloss.Style
@@ -58,19 +56,23 @@ type IssueProcessor struct {
 	issueId     string
 	mdConverter *md.Converter
 	styles      issueStyles
-	jiraClient  *myJira.Jira
+	jiraClient  myJira.Client
 }
 
-func NewIssueProcessor(action string, issueId string) IssueProcessor {
-	jiraClient, err := myJira.NewClient()
+func NewIssueProcessor(ctx context.Context, action, issueId, profile string) (IssueProcessor, error) {
+	jiraClient, err := myJira.NewClient(ctx, profile)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return IssueProcessor{}, err
 	}
+	return NewIssueProcessorForClient(action, issueId, jiraClient), nil
+}
+
+// NewIssueProcessorForClient creates an IssueProcessor working with an existing client, such as one of a test
+func NewIssueProcessorForClient(action, issueId string, jiraClient myJira.Client) IssueProcessor {
 	return IssueProcessor{
 		action:      Action(action),
 		issueId:     issueId,
-		jiraClient:  &jiraClient,
+		jiraClient:  jiraClient,
 		mdConverter: md.NewConverter("", true, &md.Options{LinkStyle: "referenced"}),
 		styles: issueStyles{
 			container: lipgloss.NewStyle().
@@ -83,10 +85,10 @@ func NewIssueProcessor(action string, issueId string) IssueProcessor {
 	}
 }
 
-func (p IssueProcessor) Process() ([]*jira.Issue, error) {
+func (p IssueProcessor) Process(ctx context.Context) ([]*jira.Issue, error) {
 	switch p.action {
 	case ActionGet:
-		issue, err := p.jiraClient.GetIssue(p.issueId)
+		issue, err := p.jiraClient.GetIssue(ctx, p.issueId)
 		if err != nil {
 			return nil, err
 		}
@@ -97,31 +99,46 @@ func (p IssueProcessor) Process() ([]*jira.Issue, error) {
 
 func (p IssueProcessor) Render(issues []*jira.Issue) error {
 	for _, issue := range issues {
+		// Jira leaves out the fields the user cannot see
+		fields, rendered := issue.Fields, issue.RenderedFields
+		if fields == nil {
+			fields = &jira.IssueFields{}
+		}
+		if rendered == nil {
+			rendered = &jira.IssueRenderedFields{}
+		}
+
 		converter := md.NewConverter("", true, &md.Options{LinkStyle: "referenced"})
-		markdown, err := converter.ConvertString(issue.RenderedFields.Description)
-		assignee := "Unassigned"
-		if issue.Fields.Assignee != nil {
-			assignee = issue.Fields.Assignee.EmailAddress
+		markdown, err := converter.ConvertString(rendered.Description)
+		if err != nil {
+			return fmt.Errorf("Failed to convert the description of %s to markdown: %w", issue.Key, err)
+		}
+		status, creator, assignee := "Unknown", "Unknown", "Unassigned"
+		if fields.Status != nil {
+			status = fields.Status.Name
+		}
+		if fields.Creator != nil {
+			creator = fields.Creator.EmailAddress
+		}
+		if fields.Assignee != nil {
+			assignee = fields.Assignee.EmailAddress
 		}
 		full := fmt.Sprintf(issuePrefix,
 			issue.Key,
-			issue.Fields.Summary,
-			p.styles.status.Render(issue.Fields.Status.Name),
-			issue.Fields.Creator.EmailAddress,
+			fields.Summary,
+			p.styles.status.Render(status),
+			creator,
 			assignee,
-			issue.RenderedFields.Created,
-			issue.RenderedFields.Updated,
+			rendered.Created,
+			rendered.Updated,
 			markdown,
 		)
 		out, err := glamour.Render(full, "dark")
-
 		if err != nil {
-			fmt.Println("Failed to render markdown with Glamour")
-			panic(err)
+			return fmt.Errorf("Failed to render markdown with Glamour: %w", err)
 		}
 
 		fmt.Println(p.styles.container.Render(out))
-
 	}
 	return nil
 }
@@ -136,22 +153,33 @@ type CommentProcessor struct {
 	commentId   string
 	action      Action
 	useMarkdown bool
+	strict      bool // Fail on markdown Jira cannot represent instead of degrading it
 	mdConverter *md.Converter
 	styles      commentStyles
-	jiraClient  myJira.Jira
+	jiraClient  myJira.Client
+	edit        func(ctx context.Context, initial string) (string, error) // Lets the user write the markdown
 }
 
-func NewCommentProcessor(action, issueId string, useMarkdown bool) CommentProcessor {
-	jiraClient, err := myJira.NewClient()
+// ErrEditorCancelled is returned when the editor is quit or left empty, nothing is sent to Jira then
+var ErrEditorCancelled = errors.New("Editor cancelled or contains no content")
+
+func NewCommentProcessor(ctx context.Context, action, issueId, profile string, useMarkdown, strict bool) (CommentProcessor, error) {
+	jiraClient, err := myJira.NewClient(ctx, profile)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return CommentProcessor{}, err
 	}
+	return NewCommentProcessorForClient(action, issueId, jiraClient, useMarkdown, strict), nil
+}
+
+// NewCommentProcessorForClient creates a CommentProcessor working with an existing client, such as one of a test
+func NewCommentProcessorForClient(action, issueId string, jiraClient myJira.Client, useMarkdown, strict bool) CommentProcessor {
 	return CommentProcessor{
 		action:      Action(action),
 		issueId:     issueId,
 		useMarkdown: useMarkdown,
+		strict:      strict,
 		jiraClient:  jiraClient,
+		edit:        editInTerminal,
 		mdConverter: md.NewConverter("", true, &md.Options{LinkStyle: "referenced"}),
 		styles: commentStyles{
 			container: lipgloss.NewStyle().
@@ -161,39 +189,50 @@ func NewCommentProcessor(action, issueId string, useMarkdown bool) CommentProces
 	}
 }
 
-func (p CommentProcessor) Process(body string) ([]*jira.Comment, error) {
+func (p CommentProcessor) Process(ctx context.Context, body string) ([]*jira.Comment, error) {
 	switch p.action {
 	case ActionAdd:
 		if p.useMarkdown {
-			err := p.AddMarkdown()
+			err := p.AddMarkdown(ctx)
 			return nil, err
 		}
-		err := p.AddBasic(body)
+		err := p.AddBasic(ctx, body)
 		if err != nil {
-			return nil, fmt.Errorf("Failed to add comment for issue %s:\n%s", p.issueId, err)
+			return nil, fmt.Errorf("Failed to add comment for issue %s:\n%w", p.issueId, err)
 		}
 		return nil, nil
 	case ActionList:
-		comments, err := p.jiraClient.GetComments(p.issueId)
+		comments, err := p.jiraClient.GetComments(ctx, p.issueId)
 		if err != nil {
-			return nil, fmt.Errorf("Failed to retrieve comments for issue %s:\n%s", p.issueId, err)
+			return nil, fmt.Errorf("Failed to retrieve comments for issue %s:\n%w", p.issueId, err)
 		}
 		return comments, nil
 	case ActionDelete:
 		fmt.Printf("Deleting comment %s for issue %s.\n", body, p.issueId)
-		err := p.jiraClient.DeleteComment(p.issueId, body)
+		err := p.jiraClient.DeleteComment(ctx, p.issueId, body)
 		if err != nil {
-			return nil, fmt.Errorf("Failed to delete comment %s in issue %s:\n%s", p.commentId, p.issueId, err)
+			return nil, fmt.Errorf("Failed to delete comment %s in issue %s:\n%w", body, p.issueId, err)
 		}
 		return nil, nil
 	case ActionUpdate:
-		comment, err := p.jiraClient.GetComment(p.issueId, body)
+		if p.wikiMarkup() {
+			comment, err := p.jiraClient.GetComment(ctx, p.issueId, body)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to get comment %s in issue %s:\n%w", body, p.issueId, err)
+			}
+			if err = p.UpdateWiki(ctx, comment); err != nil {
+				return nil, fmt.Errorf(
+					"Failed to update comment %s in issue %s:\n%w", body, p.issueId, err)
+			}
+			return nil, nil
+		}
+		comment, document, err := p.jiraClient.GetCommentADF(ctx, p.issueId, body)
 		if err != nil {
-			return nil, fmt.Errorf("Failed to get comment %s in issue %s:\n%s", body, p.issueId, err)
+			return nil, fmt.Errorf("Failed to get comment %s in issue %s:\n%w", body, p.issueId, err)
 		}
-		if err = p.UpdateMarkdown(comment); err != nil {
+		if err = p.UpdateMarkdown(ctx, comment, document); err != nil {
 			return nil, fmt.Errorf(
-				"Failed to update comment %s in issue %s:\n%s", body, p.issueId, err)
+				"Failed to update comment %s in issue %s:\n%w", body, p.issueId, err)
 		}
 		return nil, nil
 	}
@@ -204,16 +243,13 @@ func (p CommentProcessor) Render(comments []*jira.Comment) error {
 	for _, c := range comments {
 		markdown, err := p.mdConverter.ConvertString(c.Body)
 		if err != nil {
-			fmt.Println("Failed to conert HTML to Markdown.")
-			return err
+			return fmt.Errorf("Failed to convert comment %s to markdown: %w", c.ID, err)
 		}
 
 		full := fmt.Sprintf(commentPrefix,
 			c.ID, c.Author.EmailAddress, c.Created, markdown)
 		out, err := glamour.Render(full, "dark")
-
 		if err != nil {
-			fmt.Println("Failed to render markdown with Glamour")
 			return err
 		}
 
@@ -222,64 +258,210 @@ func (p CommentProcessor) Render(comments []*jira.Comment) error {
 	return nil
 }
 
-func (p CommentProcessor) AddBasic(body string) error {
-	err := p.jiraClient.AddComment(p.issueId, body)
+func (p CommentProcessor) AddBasic(ctx context.Context, body string) error {
+	return p.jiraClient.AddComment(ctx, p.issueId, body)
+}
+
+func (p CommentProcessor) AddMarkdown(ctx context.Context) error {
+	comment, err := p.edit(ctx, "")
 	if err != nil {
-		fmt.Println("Failed to create comment.")
 		return err
 	}
+
+	if p.wikiMarkup() {
+		markup, err := renderer.ToWiki([]byte(comment), p.renderOptions(ctx)...)
+		if err != nil {
+			return fmt.Errorf("Failed to render wiki markup from content: %w", err)
+		}
+		return p.jiraClient.AddComment(ctx, p.issueId, markup)
+	}
+
+	document, err := p.renderComment(ctx, comment)
+	if err != nil {
+		return fmt.Errorf("Failed to render ADF from content: %w", err)
+	}
+	if err = p.jiraClient.AddCommentCustom(ctx, p.issueId, document); err != nil {
+		return fmt.Errorf("Failed to create md comment: %w", err)
+	}
 	return nil
 }
 
-func (p CommentProcessor) AddMarkdown() error {
-	program := tea.NewProgram(editor.InitialModel())
-	if _, err := program.Run(); err != nil {
+func (p CommentProcessor) UpdateMarkdown(ctx context.Context, comment *jira.Comment, document []byte) error {
+	doc := new(renderer.Node)
+	if err := json.Unmarshal(document, doc); err != nil {
+		return fmt.Errorf("Failed to parse ADF body of comment: %v", err)
+	}
+	markdown, err := renderer.ToMarkdown(doc, p.renderOptions(ctx)...)
+	if err != nil {
 		return err
 	}
-	if editor.Quit || editor.Content == "" {
-		fmt.Println("Editor cancelled or contains no content. Exiting...")
-		os.Exit(1)
+	commentBody, err := p.edit(ctx, markdown)
+	if err != nil {
+		return err
 	}
-	comment := editor.Content
 
-	buffer := new(bytes.Buffer)
-	err := renderer.Render(buffer, []byte(comment))
+	// Tasks keep their IDs, so Jira sees them edited rather than replaced
+	body, err := p.renderComment(ctx, commentBody, renderer.WithOriginal(doc))
 	if err != nil {
-		fmt.Println("Failed to render ADF from content.")
-		return err
+		return fmt.Errorf("Failed to render ADF from content: %w", err)
 	}
-	err = p.jiraClient.AddCommentCustom(p.issueId, buffer.Bytes())
+	err = p.jiraClient.UpdateCommentCustom(ctx, p.issueId, comment.ID, body)
 	if err != nil {
-		fmt.Println("Failed to create md comment.")
-		panic(err)
+		return fmt.Errorf("Failed to create md comment: %w", err)
 	}
 	return nil
 }
 
-func (p CommentProcessor) UpdateMarkdown(comment *jira.Comment) error {
+// UpdateWiki edits a comment on Jira Server, which takes wiki markup instead of ADF.
+// Wiki markup cannot be converted back into markdown, so the comment is edited from its rendered HTML.
+func (p CommentProcessor) UpdateWiki(ctx context.Context, comment *jira.Comment) error {
 	markdown, err := p.mdConverter.ConvertString(comment.Body)
+	if err != nil {
+		return fmt.Errorf("Failed to convert comment to markdown: %v", err)
+	}
+	content, err := p.edit(ctx, markdown)
 	if err != nil {
 		return err
 	}
-	model := editor.InitialModelWithValue(markdown)
-	program := tea.NewProgram(model)
-	if _, err := program.Run(); err != nil {
-		panic(err)
+
+	markup, err := renderer.ToWiki([]byte(content), p.renderOptions(ctx)...)
+	if err != nil {
+		return fmt.Errorf("Failed to render wiki markup from content: %w", err)
+	}
+	return p.jiraClient.UpdateComment(ctx, p.issueId, comment.ID, markup)
+}
+
+// editInTerminal lets the user write markdown in the terminal, starting from the initial markdown
+func editInTerminal(ctx context.Context, initial string) (string, error) {
+	model := editor.InitialModel()
+	if initial != "" {
+		model = editor.InitialModelWithValue(initial)
+	}
+	editor.Content, editor.Quit = "", false
+	if _, err := tea.NewProgram(model, tea.WithContext(ctx)).Run(); err != nil {
+		if ctx.Err() != nil {
+			// Ctrl+C cancelled the command rather than the editor failing
+			return "", ctx.Err()
+		}
+		return "", err
 	}
 	if editor.Quit || editor.Content == "" {
-		fmt.Println("Editor cancelled or contains no content. Exiting...")
-		os.Exit(1)
+		return "", ErrEditorCancelled
 	}
-	commentBody := editor.Content
+	return editor.Content, nil
+}
+
+// wikiMarkup reports whether comments are posted as wiki markup, which Jira Server and Data Center take
+func (p CommentProcessor) wikiMarkup() bool {
+	return p.jiraClient.GetConfig().Deployment == myJira.DeploymentServer
+}
 
-	buffer := new(bytes.Buffer)
-	err = renderer.Render(buffer, []byte(commentBody))
+// renderComment converts a markdown comment into ADF and checks it before it is sent to Jira
+func (p CommentProcessor) renderComment(ctx context.Context, markdown string, opts ...renderer.Option) ([]byte, error) {
+	document, err := renderer.ToADF([]byte(markdown), append(p.renderOptions(ctx), opts...)...)
 	if err != nil {
-		return fmt.Errorf("Failed to render ADF from content: %v", err)
+		return nil, err
+	}
+	if err = renderer.Validate(document); err != nil {
+		return nil, err
+	}
+	return json.Marshal(document)
+}
+
+func (p CommentProcessor) renderOptions(ctx context.Context) []renderer.Option {
+	config := p.jiraClient.GetConfig()
+	options := []renderer.Option{
+		renderer.WithMentionResolver(mentionResolver{ctx: ctx, jiraClient: p.jiraClient}),
+		renderer.WithMediaUploader(attachmentUploader{ctx: ctx, jiraClient: p.jiraClient, issueId: p.issueId}),
+		renderer.WithSmartLinks(renderer.SmartLinks{
+			BaseURL:     config.Url,
+			ProjectKeys: config.SmartLinkProjects,
+			Hosts:       config.SmartLinkHosts,
+		}),
+		renderer.WithWarnings(func(u renderer.Unsupported) {
+			fmt.Fprintln(os.Stderr, "Warning:", u)
+		}),
+	}
+	if p.strict {
+		options = append(options, renderer.WithStrict())
 	}
-	err = p.jiraClient.UpdateCommentCustom(p.issueId, comment.ID, buffer.Bytes())
+	return options
+}
+
+// mentionResolver resolves @mentions in markdown comments to Jira accounts.
+// The renderer calls it without a context, so it carries the one of the command.
+type mentionResolver struct {
+	ctx        context.Context
+	jiraClient myJira.Client
+}
+
+func (m mentionResolver) ResolveMention(query string) (string, string, error) {
+	users, err := m.jiraClient.FindUsers(m.ctx, query)
 	if err != nil {
-		return fmt.Errorf("Failed to create md comment: %v", err)
+		return "", "", fmt.Errorf("Failed to search for users: %w", err)
 	}
-	return nil
+
+	// Prefer exact matches, the search also matches on prefixes of names and emails
+	matches := []jira.User{}
+	for _, user := range users {
+		localPart, _, _ := strings.Cut(user.EmailAddress, "@")
+		if strings.EqualFold(user.DisplayName, query) ||
+			strings.EqualFold(user.EmailAddress, query) ||
+			strings.EqualFold(localPart, query) ||
+			strings.EqualFold(user.Name, query) {
+			matches = append(matches, user)
+		}
+	}
+	if len(matches) == 0 {
+		matches = users
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", "", fmt.Errorf("No Jira user matches %q", query)
+	case 1:
+		return m.userID(matches[0]), matches[0].DisplayName, nil
+	}
+
+	candidates := make([]string, 0, len(matches))
+	for _, user := range matches {
+		candidates = append(candidates, fmt.Sprintf("@[%s|%s]", user.DisplayName, m.userID(user)))
+	}
+	return "", "", fmt.Errorf("%q matches more than one Jira user, mention one of them by account ID instead:\n\t%s",
+		query, strings.Join(candidates, "\n\t"))
+}
+
+// userID is what mentions refer to users by: account IDs on Jira Cloud, usernames on Jira Server
+func (m mentionResolver) userID(user jira.User) string {
+	return m.jiraClient.UserID(user)
+}
+
+// attachmentUploader attaches the local images of markdown comments to the issue being commented on
+type attachmentUploader struct {
+	ctx        context.Context
+	jiraClient myJira.Client
+	issueId    string
+}
+
+func (a attachmentUploader) UploadMedia(path string) (string, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer file.Close()
+
+	attachment, err := a.jiraClient.AddAttachment(a.ctx, a.issueId, filepath.Base(path), file)
+	if err != nil {
+		return "", "", fmt.Errorf("Failed to attach file to issue %s: %w", a.issueId, err)
+	}
+	if a.jiraClient.GetConfig().Deployment == myJira.DeploymentServer {
+		// Wiki markup refers to attachments by file name
+		return attachment.ID, "", nil
+	}
+	mediaID, err := a.jiraClient.GetAttachmentMediaID(a.ctx, attachment.ID)
+	if err != nil {
+		return "", "", fmt.Errorf("Failed to find the uploaded attachment %s: %w", attachment.Filename, err)
+	}
+	// Issue attachments live in the default collection
+	return mediaID, "", nil
 }
@@ -0,0 +1,325 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/andygrunwald/go-jira"
+	myJira "github.com/thaddeusrhatcher/jirate/jira"
+	"github.com/thaddeusrhatcher/jirate/jiratest"
+)
+
+var deployments = []myJira.Deployment{myJira.DeploymentCloud, myJira.DeploymentServer}
+
+// newSite starts a fake site of the deployment holding issue OPS-1 with comment 100, and connects to it
+func newSite(t *testing.T, deployment myJira.Deployment) (*jiratest.Server, myJira.Jira) {
+	t.Helper()
+	server := jiratest.NewServer(deployment)
+	t.Cleanup(server.Close)
+	server.AddIssue(jiratest.Issue{
+		Key:      "OPS-1",
+		Summary:  "Rotate the certificates",
+		Comments: []jiratest.Comment{{ID: "100", Author: server.User, Body: "Starting on it"}},
+	})
+	client, err := server.Client(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Config.Deployment != deployment {
+		t.Fatalf("Detected %s, want %s", client.Config.Deployment, deployment)
+	}
+	return server, client
+}
+
+// typing is an editor that replaces whatever it is given with the markdown, remembering what it was given
+func typing(markdown string, initial *string) func(context.Context, string) (string, error) {
+	return func(_ context.Context, value string) (string, error) {
+		if initial != nil {
+			*initial = value
+		}
+		return markdown, nil
+	}
+}
+
+func TestAddComment(t *testing.T) {
+	for _, deployment := range deployments {
+		t.Run(string(deployment), func(t *testing.T) {
+			server, client := newSite(t, deployment)
+
+			p := NewCommentProcessorForClient("add", "OPS-1", client, false, false)
+			if _, err := p.Process(context.Background(), "Done for staging"); err != nil {
+				t.Fatal(err)
+			}
+			comments := server.Comments("OPS-1")
+			if len(comments) != 2 || comments[1].Text() != "Done for staging" {
+				t.Errorf("Comments %+v, want the new one last", comments)
+			}
+		})
+	}
+}
+
+func TestAddMarkdownComment(t *testing.T) {
+	for _, deployment := range deployments {
+		t.Run(string(deployment), func(t *testing.T) {
+			server, client := newSite(t, deployment)
+
+			p := NewCommentProcessorForClient("add", "OPS-1", client, true, false)
+			p.edit = typing("Done for **staging**", nil)
+			if _, err := p.Process(context.Background(), ""); err != nil {
+				t.Fatal(err)
+			}
+
+			comments := server.Comments("OPS-1")
+			if len(comments) != 2 {
+				t.Fatalf("%d comments, want 2", len(comments))
+			}
+			added := comments[1]
+			switch deployment {
+			case myJira.DeploymentCloud:
+				if added.Document == nil || !strings.Contains(string(added.Document), `"type":"strong"`) {
+					t.Errorf("Posted %s, want an ADF document with bold text", added.Document)
+				}
+			case myJira.DeploymentServer:
+				if added.Body != "Done for *staging*" {
+					t.Errorf("Posted %q, want wiki markup", added.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestAddMarkdownCommentCancelled(t *testing.T) {
+	for _, deployment := range deployments {
+		t.Run(string(deployment), func(t *testing.T) {
+			server, client := newSite(t, deployment)
+
+			p := NewCommentProcessorForClient("add", "OPS-1", client, true, false)
+			p.edit = func(context.Context, string) (string, error) { return "", ErrEditorCancelled }
+			if _, err := p.Process(context.Background(), ""); !errors.Is(err, ErrEditorCancelled) {
+				t.Errorf("Process returned %v, want %v", err, ErrEditorCancelled)
+			}
+			if comments := server.Comments("OPS-1"); len(comments) != 1 {
+				t.Errorf("%d comments, want the one there was", len(comments))
+			}
+		})
+	}
+}
+
+func TestUpdateComment(t *testing.T) {
+	for _, deployment := range deployments {
+		t.Run(string(deployment), func(t *testing.T) {
+			server, client := newSite(t, deployment)
+
+			var initial string
+			p := NewCommentProcessorForClient("update", "OPS-1", client, true, false)
+			p.edit = typing("Finished _today_", &initial)
+			if _, err := p.Process(context.Background(), "100"); err != nil {
+				t.Fatal(err)
+			}
+			if strings.TrimSpace(initial) != "Starting on it" {
+				t.Errorf("Editor started from %q, want the comment", initial)
+			}
+
+			comments := server.Comments("OPS-1")
+			if len(comments) != 1 {
+				t.Fatalf("Comments %+v, want the updated one", comments)
+			}
+			switch deployment {
+			case myJira.DeploymentCloud:
+				if comments[0].Text() != "Finished today" || !strings.Contains(string(comments[0].Document), `"type":"em"`) {
+					t.Errorf("Updated to %s, want an ADF document with italic text", comments[0].Document)
+				}
+			case myJira.DeploymentServer:
+				if comments[0].Body != "Finished _today_" {
+					t.Errorf("Updated to %q, want wiki markup", comments[0].Body)
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteComment(t *testing.T) {
+	for _, deployment := range deployments {
+		t.Run(string(deployment), func(t *testing.T) {
+			server, client := newSite(t, deployment)
+
+			p := NewCommentProcessorForClient("delete", "OPS-1", client, false, false)
+			if _, err := p.Process(context.Background(), "100"); err != nil {
+				t.Fatal(err)
+			}
+			if comments := server.Comments("OPS-1"); len(comments) != 0 {
+				t.Errorf("Comments %+v, want none", comments)
+			}
+		})
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	tests := []struct {
+		action  string
+		issueId string
+		body    string
+	}{
+		{action: "list", issueId: "OPS-404"},
+		{action: "add", issueId: "OPS-404", body: "Hello"},
+		{action: "delete", issueId: "OPS-1", body: "404"},
+		{action: "update", issueId: "OPS-1", body: "404"},
+	}
+
+	for _, deployment := range deployments {
+		for _, test := range tests {
+			t.Run(string(deployment)+" "+test.action, func(t *testing.T) {
+				_, client := newSite(t, deployment)
+
+				p := NewCommentProcessorForClient(test.action, test.issueId, client, test.action == "update", false)
+				p.edit = typing("Hello", nil)
+				_, err := p.Process(context.Background(), test.body)
+				var apiErr *myJira.APIError
+				if !errors.As(err, &apiErr) || !apiErr.NotFound() {
+					t.Errorf("Process returned %v, want a not found *jira.APIError", err)
+				}
+			})
+		}
+	}
+
+	for _, deployment := range deployments {
+		t.Run(string(deployment)+" issue", func(t *testing.T) {
+			_, client := newSite(t, deployment)
+			_, err := NewIssueProcessorForClient("get", "OPS-404", client).Process(context.Background())
+			var apiErr *myJira.APIError
+			if !errors.As(err, &apiErr) || !apiErr.NotFound() {
+				t.Errorf("Process returned %v, want a not found *jira.APIError", err)
+			}
+		})
+	}
+}
+
+func TestGetIssue(t *testing.T) {
+	for _, deployment := range deployments {
+		t.Run(string(deployment), func(t *testing.T) {
+			_, client := newSite(t, deployment)
+
+			issues, err := NewIssueProcessorForClient("get", "OPS-1", client).Process(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(issues) != 1 || issues[0].Fields.Summary != "Rotate the certificates" {
+				t.Errorf("Got %+v, want OPS-1", issues)
+			}
+		})
+	}
+}
+
+func TestRenderIssueWithHiddenFields(t *testing.T) {
+	for _, deployment := range deployments {
+		t.Run(string(deployment), func(t *testing.T) {
+			server, client := newSite(t, deployment)
+			server.AddIssue(jiratest.Issue{
+				Key:     "OPS-2",
+				Summary: "Renew the domain",
+				Omit:    []string{"creator", "status", "renderedFields"},
+			})
+
+			p := NewIssueProcessorForClient("get", "OPS-2", client)
+			issues, err := p.Process(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if issues[0].Fields.Creator != nil || issues[0].Fields.Status != nil || issues[0].RenderedFields != nil {
+				t.Fatalf("Got %+v, want the creator, status and rendered fields left out", issues[0])
+			}
+			if err := p.Render(issues); err != nil {
+				t.Errorf("Render returned %v", err)
+			}
+		})
+	}
+}
+
+func TestNeedsCloud(t *testing.T) {
+	server, client := newSite(t, myJira.DeploymentServer)
+	document := []byte(`{"type":"doc","version":1,"content":[]}`)
+
+	if err := client.AddCommentCustom(context.Background(), "OPS-1", document); !errors.Is(err, myJira.ErrNeedsCloud) {
+		t.Errorf("AddCommentCustom returned %v, want %v", err, myJira.ErrNeedsCloud)
+	}
+	if err := client.UpdateCommentCustom(context.Background(), "OPS-1", "100", document); !errors.Is(err, myJira.ErrNeedsCloud) {
+		t.Errorf("UpdateCommentCustom returned %v, want %v", err, myJira.ErrNeedsCloud)
+	}
+	if _, err := client.GetAttachmentMediaID(context.Background(), "1"); !errors.Is(err, myJira.ErrNeedsCloud) {
+		t.Errorf("GetAttachmentMediaID returned %v, want %v", err, myJira.ErrNeedsCloud)
+	}
+
+	// The processor posts wiki markup instead, so markdown comments still work
+	p := NewCommentProcessorForClient("add", "OPS-1", client, true, false)
+	p.edit = typing("Done", nil)
+	if _, err := p.Process(context.Background(), ""); err != nil {
+		t.Errorf("Process returned %v, want the comment posted as wiki markup", err)
+	}
+	if comments := server.Comments("OPS-1"); len(comments) != 2 || comments[1].Body != "Done" {
+		t.Errorf("Comments %+v, want the new one in wiki markup", comments)
+	}
+}
+
+func TestAmbiguousMention(t *testing.T) {
+	for _, deployment := range deployments {
+		t.Run(string(deployment), func(t *testing.T) {
+			server, client := newSite(t, deployment)
+			server.AddUser(
+				jira.User{AccountID: "5b10ac8d82e05b22cc7d4ef5", Name: "jane.doe", DisplayName: "Jane Doe",
+					EmailAddress: "jane.doe@example.com"},
+				jira.User{AccountID: "5b10a2844c20165700ede21g", Name: "jane.dean", DisplayName: "Jane Dean",
+					EmailAddress: "jane.dean@example.com"},
+			)
+
+			// An exact match wins over the other users the search finds
+			p := NewCommentProcessorForClient("add", "OPS-1", client, true, false)
+			p.edit = typing("Over to @jane.doe", nil)
+			if _, err := p.Process(context.Background(), ""); err != nil {
+				t.Fatal(err)
+			}
+
+			p.edit = typing("Over to @jane", nil)
+			_, err := p.Process(context.Background(), "")
+			if err == nil || !strings.Contains(err.Error(), "matches more than one Jira user") {
+				t.Fatalf("Process returned %v, want an ambiguous mention error", err)
+			}
+			for _, candidate := range []string{"@[Jane Doe|", "@[Jane Dean|"} {
+				if !strings.Contains(err.Error(), candidate) {
+					t.Errorf("Error %q does not offer %s", err, candidate)
+				}
+			}
+			if comments := server.Comments("OPS-1"); len(comments) != 2 {
+				t.Errorf("%d comments, want only the unambiguous one added", len(comments))
+			}
+		})
+	}
+}
+
+func TestUpdateCommentKeepsTaskIDs(t *testing.T) {
+	server, client := newSite(t, myJira.DeploymentCloud)
+	server.AddIssue(jiratest.Issue{
+		Key: "OPS-2",
+		Comments: []jiratest.Comment{{ID: "200", Author: server.User, Document: []byte(`{"type":"doc","version":1,` +
+			`"content":[{"type":"taskList","attrs":{"localId":"list-1"},"content":[{"type":"taskItem",` +
+			`"attrs":{"localId":"item-1","state":"TODO"},"content":[{"type":"text","text":"Rotate the certificates"}]}]}]}`)}},
+	})
+
+	var initial string
+	p := NewCommentProcessorForClient("update", "OPS-2", client, true, false)
+	p.edit = typing("- [x] Rotate the certificates", &initial)
+	if _, err := p.Process(context.Background(), "200"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(initial) != "- [ ] Rotate the certificates" {
+		t.Errorf("Editor started from %q, want the task", initial)
+	}
+
+	document := string(server.Comments("OPS-2")[0].Document)
+	for _, want := range []string{`"localId":"list-1"`, `"localId":"item-1"`, `"state":"DONE"`} {
+		if !strings.Contains(document, want) {
+			t.Errorf("Updated to %s, want %s", document, want)
+		}
+	}
+}
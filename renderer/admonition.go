@@ -0,0 +1,132 @@
+package renderer
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+// PanelType is the style of an ADF panel
+type PanelType string
+
+// Enum values for PanelType in Attributes struct
+const (
+	PanelTypeInfo    PanelType = "info"
+	PanelTypeNote    PanelType = "note"
+	PanelTypeWarning PanelType = "warning"
+	PanelTypeError   PanelType = "error"
+	PanelTypeSuccess PanelType = "success"
+)
+
+// admonitionPanels maps GitHub alert names onto the panel with the closest colour, the panel names are accepted too
+var admonitionPanels = map[string]PanelType{
+	"NOTE":      PanelTypeInfo,
+	"IMPORTANT": PanelTypeNote,
+	"TIP":       PanelTypeSuccess,
+	"WARNING":   PanelTypeWarning,
+	"CAUTION":   PanelTypeError,
+	"INFO":      PanelTypeInfo,
+	"SUCCESS":   PanelTypeSuccess,
+	"ERROR":     PanelTypeError,
+}
+
+// panelAdmonitions maps panels back onto GitHub alert names
+var panelAdmonitions = map[PanelType]string{
+	PanelTypeInfo:    "NOTE",
+	PanelTypeNote:    "IMPORTANT",
+	PanelTypeSuccess: "TIP",
+	PanelTypeWarning: "WARNING",
+	PanelTypeError:   "CAUTION",
+}
+
+var admonitionPattern = regexp.MustCompile(`^\[!([A-Za-z]+)\]\s*$`)
+
+// KindAdmonition is a NodeKind of the Admonition node.
+var KindAdmonition = ast.NewNodeKind("Admonition")
+
+// Admonition is a block node for GitHub style alerts, a blockquote starting with a line such as [!NOTE].
+type Admonition struct {
+	ast.BaseBlock
+	PanelType PanelType
+}
+
+// Kind implements ast.Node.Kind.
+func (n *Admonition) Kind() ast.NodeKind {
+	return KindAdmonition
+}
+
+// Dump implements ast.Node.Dump.
+func (n *Admonition) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"PanelType": string(n.PanelType)}, nil)
+}
+
+// admonitionTransformer replaces blockquotes starting with an alert line by Admonition nodes
+type admonitionTransformer struct{}
+
+func (t *admonitionTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+	var blockquotes []*ast.Blockquote
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if blockquote, ok := n.(*ast.Blockquote); ok && entering {
+			blockquotes = append(blockquotes, blockquote)
+		}
+		return ast.WalkContinue, nil
+	})
+
+	for _, blockquote := range blockquotes {
+		paragraph, ok := blockquote.FirstChild().(*ast.Paragraph)
+		if !ok || paragraph.Lines().Len() == 0 {
+			continue
+		}
+		firstLine := paragraph.Lines().At(0)
+		match := admonitionPattern.FindSubmatch(firstLine.Value(source))
+		if match == nil {
+			continue
+		}
+		panelType, ok := admonitionPanels[strings.ToUpper(string(match[1]))]
+		if !ok {
+			continue
+		}
+
+		// Drop the inline nodes of the alert line, the rest of the paragraph is the first line of the panel
+		for child := paragraph.FirstChild(); child != nil; {
+			next := child.NextSibling()
+			if text, ok := child.(*ast.Text); !ok || text.Segment.Start >= firstLine.Stop {
+				break
+			}
+			paragraph.RemoveChild(paragraph, child)
+			child = next
+		}
+		if !paragraph.HasChildren() {
+			blockquote.RemoveChild(blockquote, paragraph)
+		}
+
+		admonition := &Admonition{PanelType: panelType}
+		for child := blockquote.FirstChild(); child != nil; {
+			next := child.NextSibling()
+			admonition.AppendChild(admonition, child)
+			child = next
+		}
+		if !admonition.HasChildren() {
+			// ADF panels cannot be empty
+			admonition.AppendChild(admonition, ast.NewParagraph())
+		}
+		blockquote.Parent().ReplaceChild(blockquote.Parent(), blockquote, admonition)
+	}
+}
+
+// panel writes a panel as a GitHub style alert
+func (w *markdownWriter) panel(n *Node) (string, error) {
+	content, err := w.blocks(n.Content, false)
+	if err != nil {
+		return "", err
+	}
+	alert := "> [!" + panelAdmonitions[n.Attributes.PanelType] + "]"
+	if content == "" {
+		return alert, nil
+	}
+	return alert + "\n" + prefixLines(content, "> ", ">"), nil
+}
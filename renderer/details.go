@@ -0,0 +1,133 @@
+package renderer
+
+import (
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+var (
+	detailsOpening = regexp.MustCompile(`(?is)^\s*<details(?:\s+open)?\s*>\s*(?:<summary>(.*?)</summary>)?\s*$`)
+	detailsClosing = regexp.MustCompile(`(?i)^\s*</details>\s*$`)
+)
+
+// KindDetails is a NodeKind of the Details node.
+var KindDetails = ast.NewNodeKind("Details")
+
+// Details is a block node for a collapsible section, written in markdown as HTML:
+//
+//	<details>
+//	<summary>Title</summary>
+//
+//	Markdown content, separated from the tags by blank lines
+//
+//	</details>
+type Details struct {
+	ast.BaseBlock
+	Title string
+}
+
+// Kind implements ast.Node.Kind.
+func (n *Details) Kind() ast.NodeKind {
+	return KindDetails
+}
+
+// Dump implements ast.Node.Dump.
+func (n *Details) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Title": n.Title}, nil)
+}
+
+// detailsTransformer replaces the blocks between <details> and </details> HTML blocks by Details nodes
+type detailsTransformer struct{}
+
+func (t *detailsTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+	var containers []ast.Node
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering && n.Type() != ast.TypeInline && n.HasChildren() {
+			containers = append(containers, n)
+		}
+		return ast.WalkContinue, nil
+	})
+
+	for _, container := range containers {
+		groupDetails(container, source)
+	}
+}
+
+func groupDetails(container ast.Node, source []byte) {
+	for child := container.FirstChild(); child != nil; child = child.NextSibling() {
+		match := detailsOpening.FindSubmatch(htmlBlockText(source, child))
+		if match == nil {
+			continue
+		}
+		end := closingDetails(child, source)
+		if end == nil {
+			continue
+		}
+
+		details := &Details{Title: strings.TrimSpace(html.UnescapeString(string(match[1])))}
+		for inner := child.NextSibling(); inner != end; {
+			next := inner.NextSibling()
+			details.AppendChild(details, inner)
+			inner = next
+		}
+		if !details.HasChildren() {
+			// ADF expands cannot be empty
+			details.AppendChild(details, ast.NewParagraph())
+		}
+		container.InsertBefore(container, child, details)
+		container.RemoveChild(container, child)
+		container.RemoveChild(container, end)
+		groupDetails(details, source)
+		child = details
+	}
+}
+
+// closingDetails finds the </details> that closes the section opened by opening
+func closingDetails(opening ast.Node, source []byte) ast.Node {
+	depth := 1
+	for sibling := opening.NextSibling(); sibling != nil; sibling = sibling.NextSibling() {
+		content := htmlBlockText(source, sibling)
+		if detailsOpening.Match(content) {
+			depth++
+		} else if detailsClosing.Match(content) {
+			if depth--; depth == 0 {
+				return sibling
+			}
+		}
+	}
+	return nil
+}
+
+// htmlBlockText returns the content of an HTML block, or nil for any other node
+func htmlBlockText(source []byte, n ast.Node) []byte {
+	block, ok := n.(*ast.HTMLBlock)
+	if !ok {
+		return nil
+	}
+	var content []byte
+	lines := block.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		segment := lines.At(i)
+		content = append(content, segment.Value(source)...)
+	}
+	return content
+}
+
+// expand writes an expand as a <details> section
+func (w *markdownWriter) expand(n *Node) (string, error) {
+	content, err := w.blocks(n.Content, false)
+	if err != nil {
+		return "", err
+	}
+	title := ""
+	if n.Attributes != nil {
+		title = n.Attributes.Title
+	}
+	return "<details>\n<summary>" + html.EscapeString(title) + "</summary>\n\n" + content + "\n\n</details>", nil
+}
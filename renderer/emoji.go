@@ -0,0 +1,55 @@
+package renderer
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	emojiAst "github.com/yuin/goldmark-emoji/ast"
+	"github.com/yuin/goldmark-emoji/definition"
+)
+
+// emojis are the GitHub shortcodes recognised in markdown, the same set goldmark-emoji parses
+var emojis = definition.Github()
+
+var shortcodePattern = regexp.MustCompile(`^:([A-Za-z0-9_+-]+):`)
+
+// emojiNode converts a shortcode into an ADF emoji node, ids are the hex code points Jira uses for standard emoji
+func emojiNode(n *emojiAst.Emoji) *Node {
+	node := &Node{
+		Type: NodeTypeEmoji,
+		Attributes: &Attributes{
+			ShortName: ":" + string(n.ShortName) + ":",
+		},
+	}
+	if n.Value.IsUnicode() {
+		codePoints := make([]string, 0, len(n.Value.Unicode))
+		for _, r := range n.Value.Unicode {
+			codePoints = append(codePoints, fmt.Sprintf("%x", r))
+		}
+		node.Attributes.ID = strings.Join(codePoints, "-")
+		node.Attributes.Text = string(n.Value.Unicode)
+	}
+	return node
+}
+
+// emojiShortcode returns the shortcode text starts with when it is one markdown turns into an emoji
+func emojiShortcode(text string) (string, bool) {
+	match := shortcodePattern.FindStringSubmatch(text)
+	if match == nil {
+		return "", false
+	}
+	if _, ok := emojis.Get(match[1]); !ok {
+		return "", false
+	}
+	return match[0], true
+}
+
+// emojiMarkdown writes an emoji back as its shortcode, custom emoji have no shortcode markdown understands
+func emojiMarkdown(n *Node) (string, bool) {
+	if n.Attributes == nil {
+		return "", false
+	}
+	shortcode, ok := emojiShortcode(n.Attributes.ShortName)
+	return shortcode, ok && shortcode == n.Attributes.ShortName
+}
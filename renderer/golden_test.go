@@ -0,0 +1,98 @@
+package renderer
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files with the current output")
+
+// testGolden renders every markdown file of a testdata directory and compares the ADF with the .json file next to it.
+// Run go test ./renderer -update to accept changed output.
+func testGolden(t *testing.T, dir string, opts ...Option) {
+	t.Helper()
+	inputs, err := filepath.Glob(filepath.Join("testdata", dir, "*.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) == 0 {
+		t.Fatalf("No markdown files in testdata/%s", dir)
+	}
+	for _, input := range inputs {
+		name := strings.TrimSuffix(filepath.Base(input), ".md")
+		t.Run(name, func(t *testing.T) {
+			source, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got bytes.Buffer
+			if err := Render(&got, source, opts...); err != nil {
+				t.Fatalf("Render: %v", err)
+			}
+			got.WriteByte('\n')
+
+			goldenPath := strings.TrimSuffix(input, ".md") + ".json"
+			if *update {
+				if err := os.WriteFile(goldenPath, got.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(goldenPath)
+			if err != nil {
+				t.Fatalf("%v, run go test -update to create it", err)
+			}
+			if !bytes.Equal(got.Bytes(), want) {
+				t.Errorf("%s renders differently than %s\ngot:\n%s\nwant:\n%s", input, goldenPath, got.Bytes(), want)
+			}
+		})
+	}
+}
+
+// sequentialIDs numbers local IDs instead of drawing random ones, so golden files do not change between runs
+func sequentialIDs() Option {
+	return func(r *ADFRenderer) {
+		next := 0
+		r.newLocalID = func() string {
+			next++
+			return fmt.Sprintf("local-%d", next)
+		}
+	}
+}
+
+func TestTableGolden(t *testing.T) {
+	testGolden(t, "table")
+}
+
+func TestMarksGolden(t *testing.T) {
+	testGolden(t, "marks")
+}
+
+func TestMentionsGolden(t *testing.T) {
+	testGolden(t, "mentions", WithMentionResolver(team))
+}
+
+func TestAdmonitionsGolden(t *testing.T) {
+	testGolden(t, "admonitions")
+}
+
+func TestTasksGolden(t *testing.T) {
+	testGolden(t, "tasks", sequentialIDs())
+}
+
+func TestInlineGolden(t *testing.T) {
+	testGolden(t, "inline")
+}
+
+func TestDetailsGolden(t *testing.T) {
+	testGolden(t, "details")
+}
+
+func TestExtensionsGolden(t *testing.T) {
+	testGolden(t, "extensions", sequentialIDs())
+}
@@ -0,0 +1,401 @@
+package renderer
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+// Markdown has no syntax for some of the inline formatting ADF supports, so this package adds its own:
+//
+//	++underline++
+//	H~2~O and x^2^ for subscript and superscript
+//	{color:#ff5630}colored text{color}
+//	{status:green|DONE} for a status lozenge, the color is one of the StatusColors and defaults to neutral
+//	{date:2026-10-16}
+const (
+	statusStart = "{status:"
+	dateStart   = "{date:"
+	colorStart  = "{color:"
+	colorEnd    = "{color}"
+	dateLayout  = "2006-01-02"
+)
+
+// StatusColors are the colors ADF status lozenges can have
+var StatusColors = []string{"neutral", "purple", "blue", "red", "yellow", "green"}
+
+var hexColorPattern = regexp.MustCompile(`^#(?:[0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// Enum values for the subsup mark in MarkAttributes struct
+const (
+	SubsupSub = "sub"
+	SubsupSup = "sup"
+)
+
+// KindUnderline is a NodeKind of the Underline node.
+var KindUnderline = ast.NewNodeKind("Underline")
+
+// Underline is an inline node for ++underlined text++.
+type Underline struct {
+	ast.BaseInline
+}
+
+// Kind implements ast.Node.Kind.
+func (n *Underline) Kind() ast.NodeKind {
+	return KindUnderline
+}
+
+// Dump implements ast.Node.Dump.
+func (n *Underline) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// KindSubsup is a NodeKind of the Subsup node.
+var KindSubsup = ast.NewNodeKind("Subsup")
+
+// Subsup is an inline node for ~subscript~ and ^superscript^ text.
+type Subsup struct {
+	ast.BaseInline
+	SubsupType string // Either SubsupSub or SubsupSup
+}
+
+// Kind implements ast.Node.Kind.
+func (n *Subsup) Kind() ast.NodeKind {
+	return KindSubsup
+}
+
+// Dump implements ast.Node.Dump.
+func (n *Subsup) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"SubsupType": n.SubsupType}, nil)
+}
+
+// KindTextColor is a NodeKind of the TextColor node.
+var KindTextColor = ast.NewNodeKind("TextColor")
+
+// TextColor is an inline node for {color:#ff5630}colored text{color}.
+type TextColor struct {
+	ast.BaseInline
+	Color string // Lower case #rrggbb
+}
+
+// Kind implements ast.Node.Kind.
+func (n *TextColor) Kind() ast.NodeKind {
+	return KindTextColor
+}
+
+// Dump implements ast.Node.Dump.
+func (n *TextColor) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Color": n.Color}, nil)
+}
+
+// KindStatus is a NodeKind of the Status node.
+var KindStatus = ast.NewNodeKind("Status")
+
+// Status is an inline node for {status:green|DONE} lozenges.
+type Status struct {
+	ast.BaseInline
+	Label string
+	Color string
+}
+
+// Kind implements ast.Node.Kind.
+func (n *Status) Kind() ast.NodeKind {
+	return KindStatus
+}
+
+// Dump implements ast.Node.Dump.
+func (n *Status) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Label": n.Label, "Color": n.Color}, nil)
+}
+
+// KindDate is a NodeKind of the Date node.
+var KindDate = ast.NewNodeKind("Date")
+
+// Date is an inline node for {date:2026-10-16}.
+type Date struct {
+	ast.BaseInline
+	Date time.Time
+}
+
+// Kind implements ast.Node.Kind.
+func (n *Date) Kind() ast.NodeKind {
+	return KindDate
+}
+
+// Dump implements ast.Node.Dump.
+func (n *Date) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Date": n.Date.Format(dateLayout)}, nil)
+}
+
+// colorToken marks where colored text starts or ends until the textColorTransformer pairs them up
+type colorToken struct {
+	ast.BaseInline
+	Color string // Empty for {color}
+	Raw   []byte
+}
+
+var kindColorToken = ast.NewNodeKind("ColorToken")
+
+// Kind implements ast.Node.Kind.
+func (n *colorToken) Kind() ast.NodeKind {
+	return kindColorToken
+}
+
+// Dump implements ast.Node.Dump.
+func (n *colorToken) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Color": n.Color}, nil)
+}
+
+// braceParser parses the inline extensions written in braces
+type braceParser struct{}
+
+func (p *braceParser) Trigger() []byte {
+	return []byte{'{'}
+}
+
+func (p *braceParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+	end := bytes.IndexByte(line, '}')
+	if end < 0 {
+		return nil
+	}
+	token := string(line[:end+1])
+	value := token[strings.IndexByte(token, ':')+1 : len(token)-1]
+
+	var node ast.Node
+	switch {
+	case strings.HasPrefix(token, statusStart):
+		color, label, found := strings.Cut(value, "|")
+		if !found {
+			color, label = "neutral", value
+		}
+		if label = strings.TrimSpace(label); label == "" || !isStatusColor(color) {
+			return nil
+		}
+		node = &Status{Label: label, Color: color}
+
+	case strings.HasPrefix(token, dateStart):
+		date, err := time.Parse(dateLayout, strings.TrimSpace(value))
+		if err != nil {
+			return nil
+		}
+		node = &Date{Date: date}
+
+	case strings.HasPrefix(token, colorStart):
+		color, ok := normalizeColor(strings.TrimSpace(value))
+		if !ok {
+			return nil
+		}
+		node = &colorToken{Color: color, Raw: []byte(token)}
+
+	case token == colorEnd:
+		node = &colorToken{Raw: []byte(token)}
+
+	default:
+		return nil
+	}
+
+	block.Advance(end + 1)
+	return node
+}
+
+func isStatusColor(color string) bool {
+	for _, c := range StatusColors {
+		if c == color {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeColor turns #f00 and #FF0000 into the #ff0000 form ADF expects
+func normalizeColor(color string) (string, bool) {
+	if !hexColorPattern.MatchString(color) {
+		return "", false
+	}
+	color = strings.ToLower(color)
+	if len(color) == 4 {
+		color = string([]byte{'#', color[1], color[1], color[2], color[2], color[3], color[3]})
+	}
+	return color, true
+}
+
+// delimiterProcessor turns a pair of delimiter runs into an inline node, like goldmark does for ~~strikethrough~~
+type delimiterProcessor struct {
+	char   byte
+	length int // Runs of any other length are left to other parsers
+	node   func() ast.Node
+}
+
+var (
+	underlineDelimiter   = &delimiterProcessor{char: '+', length: 2, node: func() ast.Node { return &Underline{} }}
+	subscriptDelimiter   = &delimiterProcessor{char: '~', length: 1, node: func() ast.Node { return &Subsup{SubsupType: SubsupSub} }}
+	superscriptDelimiter = &delimiterProcessor{char: '^', length: 1, node: func() ast.Node { return &Subsup{SubsupType: SubsupSup} }}
+)
+
+func (p *delimiterProcessor) IsDelimiter(b byte) bool {
+	return b == p.char
+}
+
+func (p *delimiterProcessor) CanOpenCloser(opener, closer *parser.Delimiter) bool {
+	return opener.Processor == p && closer.Processor == p
+}
+
+func (p *delimiterProcessor) OnMatch(consumes int) ast.Node {
+	return p.node()
+}
+
+// Trigger and Parse implement parser.InlineParser, each processor parses its own delimiters
+func (p *delimiterProcessor) Trigger() []byte {
+	return []byte{p.char}
+}
+
+func (p *delimiterProcessor) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	before := block.PrecendingCharacter()
+	line, segment := block.PeekLine()
+	node := parser.ScanDelimiter(line, before, p.length, p)
+	if node == nil || node.OriginalLength != p.length {
+		return nil
+	}
+	node.Segment = segment.WithStop(segment.Start + node.OriginalLength)
+	block.Advance(node.OriginalLength)
+	pc.PushDelimiter(node)
+	return node
+}
+
+// textColorTransformer wraps the text between {color:...} and {color} into TextColor nodes.
+// Tokens without a partner in the same parent are kept as text.
+type textColorTransformer struct{}
+
+func (t *textColorTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	var tokens []*colorToken
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if token, ok := n.(*colorToken); ok && entering {
+			tokens = append(tokens, token)
+		}
+		return ast.WalkContinue, nil
+	})
+
+	var open []*colorToken
+	for _, token := range tokens {
+		if token.Color != "" {
+			open = append(open, token)
+			continue
+		}
+		// Pair the end with the closest start in the same parent
+		for i := len(open) - 1; i >= 0; i-- {
+			start := open[i]
+			if start.Parent() != token.Parent() {
+				continue
+			}
+			parent := start.Parent()
+			color := &TextColor{Color: start.Color}
+			for child := start.NextSibling(); child != token; {
+				next := child.NextSibling()
+				color.AppendChild(color, child)
+				child = next
+			}
+			parent.ReplaceChild(parent, start, color)
+			parent.RemoveChild(parent, token)
+			open = append(open[:i], open[i+1:]...)
+			break
+		}
+	}
+
+	// Whatever is left over was not meant as markup
+	for _, token := range tokens {
+		if parent := token.Parent(); parent != nil {
+			parent.ReplaceChild(parent, token, ast.NewString(token.Raw))
+		}
+	}
+}
+
+// status converts a Status into an ADF status node
+func (r *renderState) status(n *Status) *Node {
+	return &Node{
+		Type: NodeTypeStatus,
+		Attributes: &Attributes{
+			Text:    n.Label,
+			Color:   n.Color,
+			LocalID: r.localID(),
+		},
+	}
+}
+
+// date converts a Date into an ADF date node, which holds midnight UTC in milliseconds
+func date(n *Date) *Node {
+	return &Node{
+		Type: NodeTypeDate,
+		Attributes: &Attributes{
+			Timestamp: strconv.FormatInt(n.Date.UnixMilli(), 10),
+		},
+	}
+}
+
+// statusMarkdown writes a status back in the brace syntax when nothing would be lost
+func (w *markdownWriter) statusMarkdown(n *Node) (string, bool) {
+	if n.Attributes == nil || !isStatusColor(n.Attributes.Color) || strings.TrimSpace(n.Attributes.Text) == "" ||
+		strings.ContainsAny(n.Attributes.Text, "|}\n") {
+		return "", false
+	}
+	expected := &Node{
+		Type: NodeTypeStatus,
+		Attributes: &Attributes{
+			Text:    n.Attributes.Text,
+			Color:   n.Attributes.Color,
+			LocalID: n.Attributes.LocalID,
+		},
+	}
+	if !sameJSON(n, expected) {
+		return "", false
+	}
+	return statusStart + n.Attributes.Color + "|" + n.Attributes.Text + "}", true
+}
+
+// dateMarkdown writes a date back in the brace syntax when it falls on midnight UTC
+func (w *markdownWriter) dateMarkdown(n *Node) (string, bool) {
+	if n.Attributes == nil {
+		return "", false
+	}
+	milliseconds, err := strconv.ParseInt(n.Attributes.Timestamp, 10, 64)
+	if err != nil {
+		return "", false
+	}
+	day := time.UnixMilli(milliseconds).UTC()
+	if !day.Equal(day.Truncate(24*time.Hour)) || !sameJSON(n, date(&Date{Date: day})) {
+		return "", false
+	}
+	return dateStart + day.Format(dateLayout) + "}", true
+}
+
+func subsupType(mark MarkStruct) string {
+	if mark.Attributes == nil || (mark.Attributes.Type != SubsupSub && mark.Attributes.Type != SubsupSup) {
+		return ""
+	}
+	return mark.Attributes.Type
+}
+
+func subsupDelimiter(mark MarkStruct) string {
+	if subsupType(mark) == SubsupSup {
+		return "^"
+	}
+	return "~"
+}
+
+// textColor returns the color of a textColor mark, or "" when it cannot be written as {color:...}
+func textColor(mark MarkStruct) string {
+	if mark.Attributes == nil {
+		return ""
+	}
+	color, ok := normalizeColor(mark.Attributes.Color)
+	if !ok || color != mark.Attributes.Color {
+		return ""
+	}
+	return color
+}
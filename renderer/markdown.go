@@ -0,0 +1,715 @@
+package renderer
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ToMarkdown converts an ADF document into markdown that Render converts back into an equivalent document.
+// It is the reverse of the ADFRenderer and is used to edit existing comments without going through HTML.
+// Nodes that markdown cannot express are written as adf placeholders so they survive the round trip.
+// The options are those the markdown will be rendered with, so smart links are only written as bare URLs
+// where they become smart links again.
+func ToMarkdown(doc *Node, opts ...Option) (string, error) {
+	if doc == nil {
+		return "", nil
+	}
+
+	w := &markdownWriter{smartLinks: NewRenderer(opts...).smartLinks}
+	out, err := w.blocks(doc.Content, false)
+	if err != nil {
+		return "", err
+	}
+	if out == "" {
+		return "", nil
+	}
+	return out + "\n", nil
+}
+
+type markdownWriter struct {
+	inTable    bool        // Pipes must be escaped inside table cells
+	singleLine bool        // Headings and table cells end with their line, line breaks in them are written inline
+	smartLinks *SmartLinks // Nil when bare URLs are never rendered as smart links
+}
+
+// blocks writes block nodes separated by blank lines. Tight blocks, used for the content of list items,
+// keep nested lists directly below the line that introduces them.
+func (w *markdownWriter) blocks(nodes []*Node, tight bool) (string, error) {
+	var out strings.Builder
+	for _, node := range nodes {
+		block, err := w.block(node)
+		if err != nil {
+			return "", err
+		}
+		if block == "" {
+			continue
+		}
+		if out.Len() > 0 {
+			if tight && (node.Type == NodeTypeBulletList || node.Type == NodeTypeOrderedList) {
+				out.WriteString("\n")
+			} else {
+				out.WriteString("\n\n")
+			}
+		}
+		out.WriteString(block)
+	}
+	return out.String(), nil
+}
+
+func (w *markdownWriter) block(n *Node) (string, error) {
+	switch n.Type {
+	case NodeTypeParagraph:
+		if len(n.Marks) > 0 {
+			// Alignment and indentation marks
+			return blockPlaceholder(n)
+		}
+		return w.inline(n.Content)
+
+	case NodeTypeHeading:
+		if len(n.Marks) > 0 {
+			return blockPlaceholder(n)
+		}
+		level := 1
+		if n.Attributes != nil && n.Attributes.Level > 0 {
+			level = n.Attributes.Level
+		}
+		w.singleLine = true
+		content, err := w.inline(n.Content)
+		w.singleLine = false
+		return strings.Repeat("#", level) + " " + content, err
+
+	case NodeTypeCodeBlock:
+		if len(n.Marks) > 0 {
+			return blockPlaceholder(n)
+		}
+		var code strings.Builder
+		for _, c := range n.Content {
+			code.WriteString(c.Text)
+		}
+		language := ""
+		if n.Attributes != nil {
+			language = n.Attributes.Language
+		}
+		return codeFence(code.String(), language), nil
+
+	case NodeTypeBlockquote:
+		content, err := w.blocks(n.Content, false)
+		if err != nil {
+			return "", err
+		}
+		return prefixLines(content, "> ", ">"), nil
+
+	case NodeTypePanel:
+		if n.Attributes == nil || panelAdmonitions[n.Attributes.PanelType] == "" {
+			return blockPlaceholder(n)
+		}
+		return w.panel(n)
+
+	case NodeTypeTaskList:
+		return w.taskList(n)
+
+	case NodeTypeExpand, NodeTypeNestedExpand:
+		if len(n.Marks) > 0 {
+			return blockPlaceholder(n)
+		}
+		return w.expand(n)
+
+	case NodeTypeBulletList:
+		return w.list(n, func(int) string { return "- " })
+
+	case NodeTypeOrderedList:
+		start := 1
+		if n.Attributes != nil && n.Attributes.Order > 0 {
+			start = n.Attributes.Order
+		}
+		return w.list(n, func(i int) string { return strconv.Itoa(start+i) + ". " })
+
+	case NodeTypeRule:
+		return "---", nil
+
+	case NodeTypeMediaSingle:
+		if image, ok := w.externalImage(n); ok {
+			return image, nil
+		}
+		return blockPlaceholder(n)
+
+	case NodeTypeTable:
+		if !markdownTable(n) {
+			return blockPlaceholder(n)
+		}
+		return w.table(n)
+
+	default:
+		return blockPlaceholder(n)
+	}
+}
+
+func (w *markdownWriter) list(n *Node, marker func(int) string) (string, error) {
+	items := make([]string, 0, len(n.Content))
+	for i, item := range n.Content {
+		content, err := w.blocks(item.Content, true)
+		if err != nil {
+			return "", err
+		}
+		prefix := marker(i)
+		indent := strings.Repeat(" ", len(prefix))
+		items = append(items, prefix+strings.TrimPrefix(prefixLines(content, indent, ""), indent))
+	}
+	return strings.Join(items, "\n"), nil
+}
+
+func (w *markdownWriter) table(n *Node) (string, error) {
+	w.inTable, w.singleLine = true, true
+	defer func() { w.inTable, w.singleLine = false, false }()
+
+	var rows [][]string
+	var alignments []string
+	columns := 0
+	for i, row := range n.Content {
+		var cells []string
+		for _, cell := range row.Content {
+			content, err := w.cell(cell)
+			if err != nil {
+				return "", err
+			}
+			cells = append(cells, content)
+			if i == 0 {
+				alignments = append(alignments, cellAlignment(cell))
+			}
+		}
+		if len(cells) > columns {
+			columns = len(cells)
+		}
+		rows = append(rows, cells)
+	}
+	if len(rows) == 0 || columns == 0 {
+		return "", nil
+	}
+
+	var out strings.Builder
+	writeRow := func(cells []string) {
+		out.WriteString("|")
+		for i := 0; i < columns; i++ {
+			cell := ""
+			if i < len(cells) {
+				cell = cells[i]
+			}
+			out.WriteString(" " + cell + " |")
+		}
+	}
+
+	writeRow(rows[0])
+	out.WriteString("\n|")
+	for i := 0; i < columns; i++ {
+		align := ""
+		if i < len(alignments) {
+			align = alignments[i]
+		}
+		switch align {
+		case "center":
+			out.WriteString(" :-: |")
+		case "end":
+			out.WriteString(" --: |")
+		default:
+			out.WriteString(" --- |")
+		}
+	}
+	for _, row := range rows[1:] {
+		out.WriteString("\n")
+		writeRow(row)
+	}
+	return out.String(), nil
+}
+
+// cell writes the single paragraph of a table cell
+func (w *markdownWriter) cell(n *Node) (string, error) {
+	if len(n.Content) == 0 {
+		return "", nil
+	}
+	return w.inline(n.Content[0].Content)
+}
+
+// markdownTable reports whether a table fits the GFM table syntax: a single header row followed by plain rows,
+// no merged or sized cells, and a single paragraph per cell aligned the same way as its column.
+func markdownTable(n *Node) bool {
+	if len(n.Content) == 0 || len(n.Marks) > 0 {
+		return false
+	}
+	if n.Attributes != nil && (n.Attributes.IsNumberColumnEnabled || (n.Attributes.Layout != "" && n.Attributes.Layout != LayoutDefault)) {
+		return false
+	}
+
+	var alignments []string
+	for i, row := range n.Content {
+		if row.Type != NodeTypeTableRow {
+			return false
+		}
+		for j, cell := range row.Content {
+			if (i == 0) != (cell.Type == NodeTypeTableHeader) || (cell.Type != NodeTypeTableHeader && cell.Type != NodeTypeTableCell) {
+				return false
+			}
+			if a := cell.Attributes; a != nil && (a.Colspan > 1 || a.Rowspan > 1 || len(a.Colwidth) > 0 || a.Background != "") {
+				return false
+			}
+			if len(cell.Content) > 1 || (len(cell.Content) == 1 && cell.Content[0].Type != NodeTypeParagraph) {
+				return false
+			}
+			for _, paragraph := range cell.Content {
+				for _, mark := range paragraph.Marks {
+					if mark.Type != MarkAlignment {
+						return false
+					}
+				}
+			}
+			if i == 0 {
+				alignments = append(alignments, cellAlignment(cell))
+			} else if j >= len(alignments) || cellAlignment(cell) != alignments[j] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func cellAlignment(cell *Node) string {
+	for _, c := range cell.Content {
+		for _, mark := range c.Marks {
+			if mark.Type == MarkAlignment && mark.Attributes != nil {
+				return mark.Attributes.Align
+			}
+		}
+	}
+	return ""
+}
+
+// inline writes inline nodes, opening and closing marks only where they change between neighbouring nodes
+// so that text formatted across several ADF nodes stays a single markdown span.
+func (w *markdownWriter) inline(nodes []*Node) (string, error) {
+	var out strings.Builder
+	var active []MarkStruct
+	pending := "" // Trailing whitespace is held back so it ends up outside of closing delimiters
+
+	closeMarks := func(keep int) {
+		for i := len(active) - 1; i >= keep; i-- {
+			out.WriteString(closingDelimiter(active[i]))
+		}
+		active = active[:keep]
+	}
+	// lineStart reports whether only whitespace was written on the current line so far
+	lineStart := func() bool {
+		written := out.String() + pending
+		return strings.TrimLeft(written[strings.LastIndexByte(written, '\n')+1:], " \t") == ""
+	}
+
+	for i, n := range nodes {
+		if n.Type == NodeTypeHardBreak {
+			// Hard breaks keep the marks shared with the next node open, a trailing one has nothing to break
+			if i < len(nodes)-1 {
+				_, next, err := w.inlineContent(nodes[i+1], false)
+				if err != nil {
+					return "", err
+				}
+				closeMarks(sharedMarks(active, orderMarks(active, next)))
+				if w.singleLine {
+					out.WriteString(pending + "<br>")
+				} else {
+					out.WriteString(pending + "\\\n")
+				}
+				pending = ""
+			}
+			continue
+		}
+
+		text, marks, err := w.inlineContent(n, lineStart())
+		if err != nil {
+			return "", err
+		}
+
+		core := strings.TrimLeftFunc(text, unicode.IsSpace)
+		lead := text[:len(text)-len(core)]
+		core = strings.TrimRightFunc(core, unicode.IsSpace)
+		trail := text[len(lead)+len(core):]
+		if core == "" && n.Type == NodeTypeText {
+			// Whitespace only closes the marks it does not share, it never opens new ones
+			closeMarks(sharedMarks(active, orderMarks(active, marks)))
+			pending += text
+			continue
+		}
+
+		marks = orderMarks(active, marks)
+		keep := sharedMarks(active, marks)
+		closeMarks(keep)
+		out.WriteString(pending + lead)
+		pending = trail
+		for _, mark := range marks[keep:] {
+			out.WriteString(openingDelimiter(mark))
+			active = append(active, mark)
+		}
+
+		if hasMark(marks, MarkCode) {
+			out.WriteString(codeSpan(core))
+			// The code span delimiters were written as a unit, the code mark itself must not be closed again
+			active = active[:len(active)-1]
+			continue
+		}
+		out.WriteString(core)
+	}
+	closeMarks(0)
+	out.WriteString(pending)
+
+	return strings.TrimRightFunc(out.String(), unicode.IsSpace), nil
+}
+
+// inlineContent returns the markdown for a single inline node along with the marks that apply to it.
+// lineStart tells whether the node starts a line, where text can be mistaken for the start of a block.
+func (w *markdownWriter) inlineContent(n *Node, lineStart bool) (string, []MarkStruct, error) {
+	switch n.Type {
+	case NodeTypeText:
+		for _, mark := range n.Marks {
+			switch mark.Type {
+			case MarkCode, MarkEm, MarkLink, MarkStrike, MarkStrong, MarkUnderline:
+			case MarkSubsup:
+				// A single ~ next to the ~~ of strikethrough would read as a longer run
+				if subsupType(mark) == "" || (subsupType(mark) == SubsupSub && hasMark(n.Marks, MarkStrike)) {
+					placeholder, err := w.inlinePlaceholder(n)
+					return placeholder, nil, err
+				}
+			case MarkTextcolor:
+				if textColor(mark) == "" {
+					placeholder, err := w.inlinePlaceholder(n)
+					return placeholder, nil, err
+				}
+			default:
+				placeholder, err := w.inlinePlaceholder(n)
+				return placeholder, nil, err
+			}
+		}
+		if hasMark(n.Marks, MarkCode) {
+			return n.Text, n.Marks, nil
+		}
+		return w.escape(n.Text, lineStart), n.Marks, nil
+
+	case NodeTypeInlineCard:
+		// Bare URLs on the smart link hosts become inline cards again, elsewhere they would come back as links
+		if link := n.Attributes; link != nil && link.URL != "" && !strings.ContainsAny(link.URL, " <>") &&
+			w.smartLinks != nil && w.smartLinks.matchesURL(link.URL) {
+			return "<" + link.URL + ">", nil, nil
+		}
+
+	case NodeTypeMention:
+		if n.Attributes != nil && n.Attributes.ID != "" {
+			return w.mentionMarkdown(n), nil, nil
+		}
+
+	case NodeTypeEmoji:
+		if shortcode, ok := emojiMarkdown(n); ok {
+			return shortcode, nil, nil
+		}
+
+	case NodeTypeStatus:
+		if status, ok := w.statusMarkdown(n); ok {
+			return status, nil, nil
+		}
+
+	case NodeTypeDate:
+		if date, ok := w.dateMarkdown(n); ok {
+			return date, nil, nil
+		}
+	}
+
+	placeholder, err := w.inlinePlaceholder(n)
+	return placeholder, nil, err
+}
+
+// orderMarks keeps marks that are already open first so they are not closed and reopened.
+// Links wrap everything else and code spans are always innermost since they cannot contain other markup.
+func orderMarks(active, marks []MarkStruct) []MarkStruct {
+	ordered := make([]MarkStruct, 0, len(marks))
+	for _, mark := range active {
+		if mark.Type != MarkCode && containsMark(marks, mark) {
+			ordered = append(ordered, mark)
+		}
+	}
+	for _, markType := range []Mark{MarkLink, MarkTextcolor, MarkStrong, MarkEm, MarkUnderline, MarkStrike, MarkSubsup, MarkCode} {
+		for _, mark := range marks {
+			if mark.Type == markType && !containsMark(ordered, mark) {
+				ordered = append(ordered, mark)
+			}
+		}
+	}
+	return ordered
+}
+
+// sharedMarks counts the open marks that can stay open for the next node
+func sharedMarks(active, marks []MarkStruct) int {
+	keep := 0
+	for keep < len(active) && keep < len(marks) && marksEqual(active[keep], marks[keep]) {
+		keep++
+	}
+	return keep
+}
+
+func containsMark(marks []MarkStruct, mark MarkStruct) bool {
+	for _, m := range marks {
+		if marksEqual(m, mark) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasMark(marks []MarkStruct, markType Mark) bool {
+	for _, m := range marks {
+		if m.Type == markType {
+			return true
+		}
+	}
+	return false
+}
+
+func marksEqual(a, b MarkStruct) bool {
+	if a.Type != b.Type {
+		return false
+	}
+	if a.Attributes == nil || b.Attributes == nil {
+		return a.Attributes == b.Attributes
+	}
+	return *a.Attributes == *b.Attributes
+}
+
+func openingDelimiter(mark MarkStruct) string {
+	switch mark.Type {
+	case MarkStrong:
+		return "**"
+	case MarkEm:
+		return "*"
+	case MarkStrike:
+		return "~~"
+	case MarkUnderline:
+		return "++"
+	case MarkSubsup:
+		return subsupDelimiter(mark)
+	case MarkTextcolor:
+		return colorStart + textColor(mark) + "}"
+	case MarkLink:
+		return "["
+	}
+	return ""
+}
+
+func closingDelimiter(mark MarkStruct) string {
+	switch mark.Type {
+	case MarkStrong:
+		return "**"
+	case MarkEm:
+		return "*"
+	case MarkStrike:
+		return "~~"
+	case MarkUnderline:
+		return "++"
+	case MarkSubsup:
+		return subsupDelimiter(mark)
+	case MarkTextcolor:
+		return colorEnd
+	case MarkLink:
+		if mark.Attributes == nil {
+			return "]()"
+		}
+		destination := linkDestination(mark.Attributes.Href)
+		if mark.Attributes.Title != "" {
+			destination += ` "` + strings.ReplaceAll(mark.Attributes.Title, `"`, `\"`) + `"`
+		}
+		return "](" + destination + ")"
+	}
+	return ""
+}
+
+func linkDestination(href string) string {
+	if strings.ContainsAny(href, " ()<>") {
+		return "<" + strings.NewReplacer("<", "%3C", ">", "%3E").Replace(href) + ">"
+	}
+	return href
+}
+
+// codeSpan wraps code in enough backticks that none of the backticks it contains end the span early
+func codeSpan(code string) string {
+	delimiter := strings.Repeat("`", longestRun(code, '`')+1)
+	if strings.HasPrefix(code, "`") || strings.HasSuffix(code, "`") {
+		code = " " + code + " "
+	}
+	return delimiter + code + delimiter
+}
+
+func codeFence(code, language string) string {
+	fence := "```"
+	if run := longestRun(code, '`'); run >= 3 {
+		fence = strings.Repeat("`", run+1)
+	}
+	code = strings.TrimSuffix(code, "\n")
+	return fence + language + "\n" + code + "\n" + fence
+}
+
+func longestRun(s string, c rune) int {
+	longest, current := 0, 0
+	for _, r := range s {
+		if r == c {
+			current++
+			if current > longest {
+				longest = current
+			}
+		} else {
+			current = 0
+		}
+	}
+	return longest
+}
+
+// prefixLines prefixes every line of s, empty lines get emptyPrefix so no trailing whitespace is produced
+func prefixLines(s, prefix, emptyPrefix string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if line == "" {
+			lines[i] = emptyPrefix
+		} else {
+			lines[i] = prefix + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// escape backslash escapes characters that would otherwise be parsed as markdown syntax.
+// lineStart tells whether the text starts a line, the start of every line after a newline in the text is checked too.
+func (w *markdownWriter) escape(text string, lineStart bool) string {
+	var out strings.Builder
+	runes := []rune(text)
+	indented := lineStart // Only indentation precedes the rune on its line
+	lineFrom := -1        // Where the text of the current line starts after its indentation, -1 when not at a line start
+	if lineStart {
+		lineFrom = 0
+	}
+	for i, r := range runes {
+		if r == '\n' {
+			if w.singleLine {
+				// A line break would end the heading or table
+				out.WriteString("&#10;")
+				continue
+			}
+			out.WriteRune(r)
+			indented, lineFrom = true, i+1
+			continue
+		}
+		if indented && (r == ' ' || r == '\t') {
+			// Leading whitespace would be stripped, or indent the line into a code block
+			out.WriteString("&#" + strconv.Itoa(int(r)) + ";")
+			lineFrom = i + 1
+			continue
+		}
+		first := indented // Text that starts a line can start a block
+		indented = false
+
+		switch r {
+		case '\\', '*', '`', '[', ']', '<', '~', '^':
+			out.WriteRune('\\')
+		case '+':
+			// Only a pair of pluses underlines, a single one can still start a list
+			if first || (i > 0 && runes[i-1] == '+') || (i < len(runes)-1 && runes[i+1] == '+') {
+				out.WriteRune('\\')
+			}
+		case '|':
+			if w.inTable {
+				out.WriteRune('\\')
+			}
+		case '_':
+			// Underscores inside of words never start emphasis
+			if i == 0 || i == len(runes)-1 || !isWordRune(runes[i-1]) || !isWordRune(runes[i+1]) {
+				out.WriteRune('\\')
+			}
+		case '&':
+			if looksLikeEntity(runes[i+1:]) {
+				out.WriteRune('\\')
+			}
+		case '@':
+			// Keep literal text from being read as a mention, or linked as an email address
+			if i == 0 || (!isWordRune(runes[i-1]) && runes[i-1] != '.') || looksLikeDomain(runes[i+1:]) {
+				out.WriteRune('\\')
+			}
+		case '{':
+			// Keep literal text from being read as an inline placeholder or one of the brace extensions
+			if rest := string(runes[i:]); strings.HasPrefix(rest, inlinePlaceholderStart) ||
+				strings.HasPrefix(rest, statusStart) || strings.HasPrefix(rest, dateStart) ||
+				strings.HasPrefix(rest, colorStart) || strings.HasPrefix(rest, colorEnd) {
+				out.WriteRune('\\')
+			}
+		case ':':
+			// Keep literal text from being read as an emoji, or linked as a URL
+			if _, ok := emojiShortcode(string(runes[i:])); ok || hasSuffixFold(runes[:i], "http", "https", "ftp") {
+				out.WriteRune('\\')
+			}
+		case '#', '>', '-', '=':
+			if first {
+				out.WriteRune('\\')
+			}
+		case '.', ')':
+			// Keep "1. text" at the start of a line from becoming an ordered list, and www.example.com from a link
+			if (lineFrom >= 0 && i > lineFrom && isDigits(runes[lineFrom:i])) || (r == '.' && hasSuffixFold(runes[:i], "www")) {
+				out.WriteRune('\\')
+			}
+		}
+		if !first && lineFrom >= 0 && !unicode.IsDigit(r) {
+			lineFrom = -1
+		}
+		out.WriteRune(r)
+	}
+	return out.String()
+}
+
+// hasSuffixFold reports whether the text ends with one of the words, ignoring case
+func hasSuffixFold(runes []rune, words ...string) bool {
+	for _, word := range words {
+		if len(runes) >= len(word) && strings.EqualFold(string(runes[len(runes)-len(word):]), word) {
+			return true
+		}
+	}
+	return false
+}
+
+// looksLikeDomain reports whether the text after an @ starts with a domain name, which GFM links as an email address
+func looksLikeDomain(runes []rune) bool {
+	dot := false
+	for i, r := range runes {
+		switch {
+		case isWordRune(r) || r == '-' || r == '_':
+		case r == '.' && i > 0 && i < len(runes)-1 && isWordRune(runes[i+1]):
+			dot = true
+		default:
+			return dot
+		}
+	}
+	return dot
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func isDigits(runes []rune) bool {
+	for _, r := range runes {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func looksLikeEntity(runes []rune) bool {
+	for i, r := range runes {
+		if r == ';' {
+			return i > 0
+		}
+		if !isWordRune(r) && r != '#' {
+			return false
+		}
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+package renderer
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// paragraphDoc is the JSON of a document holding a single paragraph of inline nodes
+func paragraphDoc(inline string) string {
+	return `{"type":"doc","version":1,"content":[{"type":"paragraph","content":[` + inline + `]}]}`
+}
+
+func textJSON(s string) string {
+	quoted, _ := json.Marshal(s)
+	return `{"type":"text","text":` + string(quoted) + `}`
+}
+
+func TestMarkdownRoundTrip(t *testing.T) {
+	smartLinks := WithSmartLinks(SmartLinks{BaseURL: "https://example.atlassian.net"})
+	tests := []struct {
+		name    string
+		adf     string
+		want    string // The document Render returns for the markdown, the input when empty
+		options []Option
+	}{
+		{name: "four leading spaces", adf: paragraphDoc(textJSON("    not code"))},
+		{name: "one leading space", adf: paragraphDoc(textJSON(" one"))},
+		{name: "three leading spaces", adf: paragraphDoc(textJSON("   three"))},
+		{name: "leading tab", adf: paragraphDoc(textJSON("\tcolumn"))},
+		{name: "leading spaces after a hard break", adf: paragraphDoc(textJSON("a") + `,{"type":"hardBreak"},` + textJSON("    b"))},
+		{name: "leading spaces in bold", adf: paragraphDoc(`{"type":"text","text":"  bold","marks":[{"type":"strong"}]}`)},
+		{name: "http URL", adf: paragraphDoc(textJSON("see http://example.com/path now"))},
+		{name: "https URL", adf: paragraphDoc(textJSON("https://example.com"))},
+		{name: "ftp URL", adf: paragraphDoc(textJSON("get ftp://files.example.com"))},
+		{name: "www domain", adf: paragraphDoc(textJSON("www.example.com"))},
+		{name: "email address", adf: paragraphDoc(textJSON("mail me@x.com please"))},
+		{name: "address without a domain", adf: paragraphDoc(textJSON("user@localhost"))},
+		{name: "ordered list marker", adf: paragraphDoc(textJSON("1. not a list"))},
+		{name: "bullet list marker", adf: paragraphDoc(textJSON("- not a list"))},
+		{name: "number mid paragraph", adf: paragraphDoc(textJSON("version ") + `,{"type":"text","text":"2","marks":[{"type":"strong"}]},` + textJSON(". done"))},
+		{
+			// A newline in text is a soft line break in markdown, which reads back as a space
+			name: "bullet list after a newline",
+			adf:  paragraphDoc(textJSON("a\n- b")),
+			want: paragraphDoc(textJSON("a - b")),
+		},
+		{
+			name: "ordered list after a newline",
+			adf:  paragraphDoc(textJSON("a\n1. x")),
+			want: paragraphDoc(textJSON("a 1. x")),
+		},
+		{
+			name: "heading after a newline",
+			adf:  paragraphDoc(textJSON("a\n# b")),
+			want: paragraphDoc(textJSON("a # b")),
+		},
+		{
+			name: "code block after a newline",
+			adf:  paragraphDoc(textJSON("a\n\n    b")),
+			want: `{"type":"doc","version":1,"content":[{"type":"paragraph","content":[` + textJSON("a") +
+				`]},{"type":"paragraph","content":[` + textJSON("    b") + `]}]}`,
+		},
+		{
+			name: "code block without a language",
+			adf:  `{"type":"doc","version":1,"content":[{"type":"codeBlock","content":[` + textJSON("x := 1\n") + `]}]}`,
+		},
+		{
+			name: "code block with a language",
+			adf: `{"type":"doc","version":1,"content":[{"type":"codeBlock","attrs":{"language":"go"},"content":[` +
+				textJSON("x := 1\n") + `]}]}`,
+		},
+		{
+			name: "newline in a heading",
+			adf:  `{"type":"doc","version":1,"content":[{"type":"heading","attrs":{"level":2},"content":[` + textJSON("a\n- b") + `]}]}`,
+		},
+		{
+			name: "hard break in a heading",
+			adf: `{"type":"doc","version":1,"content":[{"type":"heading","attrs":{"level":1},"content":[` +
+				textJSON("a") + `,{"type":"hardBreak"},` + textJSON("b") + `]}]}`,
+		},
+		{name: "escaped entity", adf: paragraphDoc(textJSON("&copy; and &amp; and &#35;"))},
+		{name: "backslash before an entity", adf: paragraphDoc(textJSON(`\&copy;`))},
+		{
+			name: "inline card off the smart link hosts",
+			adf:  paragraphDoc(textJSON("see ") + `,{"type":"inlineCard","attrs":{"url":"https://other.example.com/page"}}`),
+		},
+		{
+			name:    "inline card on a smart link host",
+			adf:     paragraphDoc(textJSON("see ") + `,{"type":"inlineCard","attrs":{"url":"https://example.atlassian.net/browse/OPS-1"}}`),
+			options: []Option{smartLinks},
+		},
+		{
+			name:    "inline card off the configured hosts",
+			adf:     paragraphDoc(textJSON("see ") + `,{"type":"inlineCard","attrs":{"url":"https://other.example.com/page"}}`),
+			options: []Option{smartLinks},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			doc := new(Node)
+			if err := json.Unmarshal([]byte(test.adf), doc); err != nil {
+				t.Fatal(err)
+			}
+			markdown, err := ToMarkdown(doc, test.options...)
+			if err != nil {
+				t.Fatalf("ToMarkdown: %v", err)
+			}
+			got, err := ToADF([]byte(markdown), test.options...)
+			if err != nil {
+				t.Fatalf("ToADF(%q): %v", markdown, err)
+			}
+
+			want := test.want
+			if want == "" {
+				want = test.adf
+			}
+			if !sameDocument(t, got, want) {
+				gotJSON, _ := json.Marshal(got)
+				t.Errorf("markdown %q renders to\n%s\nwant\n%s", markdown, gotJSON, want)
+			}
+		})
+	}
+}
+
+func TestLinkReferenceDefinitions(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		want     string
+	}{
+		{
+			name:     "before the paragraph using it",
+			markdown: "[docs]: https://example.com\n\nSee [docs].",
+			want: paragraphDoc(textJSON("See ") + `,{"type":"text","text":"docs","marks":[{"type":"link",` +
+				`"attrs":{"href":"https://example.com"}}]},` + textJSON(".")),
+		},
+		{
+			name:     "alone",
+			markdown: "[docs]: https://example.com",
+			want:     `{"type":"doc","version":1}`,
+		},
+		{
+			name:     "in a list item",
+			markdown: "- [docs]: https://example.com",
+			want: `{"type":"doc","version":1,"content":[{"type":"bulletList","content":[{"type":"listItem",` +
+				`"content":[{"type":"paragraph"}]}]}]}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ToADF([]byte(test.markdown))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sameDocument(t, got, test.want) {
+				gotJSON, _ := json.Marshal(got)
+				t.Errorf("ToADF(%q) =\n%s\nwant\n%s", test.markdown, gotJSON, test.want)
+			}
+			if err := Validate(got); err != nil {
+				t.Errorf("Validate: %v", err)
+			}
+		})
+	}
+}
+
+// sameDocument compares a document with the JSON of the expected one, regardless of key order
+func sameDocument(t *testing.T, got *Node, want string) bool {
+	t.Helper()
+	gotJSON, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sameJSON(&Node{raw: gotJSON}, &Node{raw: []byte(want)})
+}
@@ -0,0 +1,217 @@
+package renderer
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/gif"  // Registers GIF for reading image sizes.
+	_ "image/jpeg" // Registers JPEG for reading image sizes.
+	_ "image/png"  // Registers PNG for reading image sizes.
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+)
+
+// MediaUploader stores the local files images point at, so they can be embedded in the document.
+// The returned ID and collection identify the uploaded file in Atlassian's media store.
+type MediaUploader interface {
+	UploadMedia(path string) (id string, collection string, err error)
+}
+
+// WithMediaUploader uploads local images and embeds them as media.
+// Images are only uploaded once the whole document rendered and passed Validate, so a rejected document leaves
+// nothing behind. Without an uploader local images are kept as their alt text.
+func WithMediaUploader(uploader MediaUploader) Option {
+	return func(r *ADFRenderer) {
+		r.media = uploader
+	}
+}
+
+// Enum values for the media type in Attributes struct
+const (
+	MediaTypeFile     = "file"
+	MediaTypeExternal = "external"
+)
+
+// image converts an image into a media single node, or returns nil when the image cannot be embedded.
+// Local images are uploaded by upload once the whole document is rendered.
+func (r *renderState) image(source []byte, n *ast.Image) *Node {
+	destination := string(n.Destination)
+	alt := altText(source, n)
+
+	media := &Node{
+		Type: NodeTypeMedia,
+		Attributes: &Attributes{
+			Alt: alt,
+		},
+	}
+	if link, err := url.Parse(destination); err == nil && (link.Scheme == "http" || link.Scheme == "https") {
+		media.Attributes.Type = MediaTypeExternal
+		media.Attributes.URL = destination
+	} else {
+		path := localPath(destination)
+		if r.media == nil || path == "" {
+			return nil
+		}
+		// The path stands in for the media ID until the file is uploaded
+		media.Attributes.Type = MediaTypeFile
+		media.Attributes.ID = path
+		media.Attributes.Collection = new(string)
+		media.Attributes.Width, media.Attributes.Height = imageSize(path)
+		r.uploads = append(r.uploads, upload{path: path, destination: destination, media: media})
+	}
+
+	return &Node{
+		Type: NodeTypeMediaSingle,
+		Attributes: &Attributes{
+			Layout: LayoutCenter,
+		},
+		Content: []*Node{media},
+	}
+}
+
+// upload is a local image waiting to be uploaded
+type upload struct {
+	path        string
+	destination string
+	media       *Node // The media node to point at the uploaded file, nil in wiki markup
+}
+
+// upload stores the local images of the document with the media uploader, filling in the IDs of their media nodes.
+// Images used more than once are uploaded once.
+func (r *renderState) upload() error {
+	type uploaded struct{ id, collection string }
+	done := map[string]uploaded{}
+	for _, u := range r.uploads {
+		file, ok := done[u.path]
+		if !ok {
+			var err error
+			if file.id, file.collection, err = r.media.UploadMedia(u.path); err != nil {
+				return fmt.Errorf("image %s: %w", u.destination, err)
+			}
+			done[u.path] = file
+		}
+		if u.media != nil {
+			u.media.Attributes.ID = file.id
+			u.media.Attributes.Collection = &file.collection
+		}
+	}
+	return nil
+}
+
+// imageText is what is left of an image that cannot be embedded, remote images stay reachable through a link
+func (r *renderState) imageText(source []byte, n *ast.Image) *Node {
+	text := &Node{
+		Type:  NodeTypeText,
+		Text:  altText(source, n),
+		Marks: r.textMarks(),
+	}
+	if text.Text == "" {
+		text.Text = string(n.Destination)
+	}
+	if link, err := url.Parse(string(n.Destination)); err == nil && (link.Scheme == "http" || link.Scheme == "https") &&
+		!hasMark(r.marks, MarkLink) {
+		text.Marks = append(text.Marks, MarkStruct{
+			Type:       MarkLink,
+			Attributes: &MarkAttributes{Href: string(n.Destination)},
+		})
+	}
+	return text
+}
+
+// altText is the plain text of the description of an image
+func altText(source []byte, n ast.Node) string {
+	var alt strings.Builder
+	for child := n.FirstChild(); child != nil; child = child.NextSibling() {
+		switch child := child.(type) {
+		case *ast.Text:
+			alt.WriteString(unescapedText(child.Text(source)))
+			if child.SoftLineBreak() {
+				alt.WriteString(" ")
+			}
+		case *ast.String:
+			alt.Write(child.Value)
+		default:
+			alt.WriteString(altText(source, child))
+		}
+	}
+	return alt.String()
+}
+
+// localPath returns the file an image destination refers to, or "" when it is not a local file.
+// Paths without a scheme are only files when they exist, /secure/attachment/10000/chart.png is a link on the site.
+func localPath(destination string) string {
+	path := destination
+	if link, err := url.Parse(destination); err == nil {
+		switch link.Scheme {
+		case "":
+			if unescaped, err := url.PathUnescape(destination); err == nil {
+				path = unescaped
+			}
+		case "file":
+			path = link.Path
+		default:
+			return ""
+		}
+	}
+	if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+		return ""
+	}
+	return path
+}
+
+// imageSize reads the pixel size of an image, Jira uses it to reserve space while the image loads
+func imageSize(path string) (width, height float32) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0
+	}
+	defer file.Close()
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return 0, 0
+	}
+	return float32(config.Width), float32(config.Height)
+}
+
+// externalImage writes a media single back as an image when it is exactly what the image would render to
+func (w *markdownWriter) externalImage(n *Node) (string, bool) {
+	if len(n.Content) != 1 || n.Content[0].Attributes == nil {
+		return "", false
+	}
+	media := n.Content[0].Attributes
+	if media.Type != MediaTypeExternal || media.URL == "" || strings.ContainsAny(media.URL, " <>") ||
+		strings.ContainsAny(media.Alt, "\n") {
+		return "", false
+	}
+
+	expected := &Node{
+		Type:       NodeTypeMediaSingle,
+		Attributes: &Attributes{Layout: LayoutCenter},
+		Content: []*Node{{
+			Type:       NodeTypeMedia,
+			Attributes: &Attributes{Type: MediaTypeExternal, URL: media.URL, Alt: media.Alt},
+		}},
+	}
+	if !sameJSON(n, expected) {
+		return "", false
+	}
+	return "![" + w.escape(media.Alt, false) + "](" + linkDestination(media.URL) + ")", true
+}
+
+// sameJSON compares the JSON of two nodes regardless of key order, using the JSON a node was parsed from
+func sameJSON(a, b *Node) bool {
+	rawA, errA := originalJSON(a)
+	rawB, errB := originalJSON(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	var valueA, valueB any
+	if json.Unmarshal(rawA, &valueA) != nil || json.Unmarshal(rawB, &valueB) != nil {
+		return false
+	}
+	return reflect.DeepEqual(valueA, valueB)
+}
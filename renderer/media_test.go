@@ -0,0 +1,179 @@
+package renderer
+
+import (
+	"encoding/json"
+	"errors"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// recordingUploader remembers the files it is asked to upload
+type recordingUploader struct {
+	paths []string
+	err   error
+}
+
+func (u *recordingUploader) UploadMedia(path string) (string, string, error) {
+	u.paths = append(u.paths, path)
+	if u.err != nil {
+		return "", "", u.err
+	}
+	return "media-" + path, "", nil
+}
+
+// writeImage writes a small PNG to a temporary directory and returns its path
+func writeImage(t *testing.T, name string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, image.NewGray(image.Rect(0, 0, 4, 3))); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// unsupportedMarkdown is degraded to text in ADF and wiki markup, so strict mode rejects it
+const unsupportedMarkdown = "some <kbd>HTML</kbd>"
+
+func TestImageUpload(t *testing.T) {
+	chart := writeImage(t, "chart.png")
+	uploader := &recordingUploader{}
+	doc, err := ToADF([]byte("![chart]("+chart+")\n\n![again]("+chart+")"), WithMediaUploader(uploader))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uploader.paths) != 1 || uploader.paths[0] != chart {
+		t.Errorf("Uploaded %q, want %s once", uploader.paths, chart)
+	}
+	if err := Validate(doc); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := json.Marshal("media-" + chart)
+	if strings.Count(string(raw), `"id":`+string(id)) != 2 || strings.Count(string(raw), `"collection":""`) != 2 ||
+		strings.Count(string(raw), `"width":4`) != 2 {
+		t.Errorf("Want 2 media nodes with the uploaded ID, an empty collection and the image size:\n%s", raw)
+	}
+}
+
+func TestImageUploadWaitsForAcceptedDocument(t *testing.T) {
+	markdown := []byte("![chart](" + writeImage(t, "chart.png") + ")\n\n" + unsupportedMarkdown)
+
+	uploader := &recordingUploader{}
+	_, err := ToADF(markdown, WithMediaUploader(uploader), WithStrict())
+	var unsupported *UnsupportedError
+	if !errors.As(err, &unsupported) {
+		t.Errorf("ToADF returned %v, want an *UnsupportedError", err)
+	}
+	if len(uploader.paths) > 0 {
+		t.Errorf("ToADF uploaded %q for a rejected document", uploader.paths)
+	}
+
+	uploader = &recordingUploader{}
+	_, err = ToWiki(markdown, WithMediaUploader(uploader), WithStrict())
+	if !errors.As(err, &unsupported) {
+		t.Errorf("ToWiki returned %v, want an *UnsupportedError", err)
+	}
+	if len(uploader.paths) > 0 {
+		t.Errorf("ToWiki uploaded %q for a rejected document", uploader.paths)
+	}
+}
+
+func TestImageUploadError(t *testing.T) {
+	failed := errors.New("quota exceeded")
+	_, err := ToADF([]byte("![chart]("+writeImage(t, "chart.png")+")"), WithMediaUploader(&recordingUploader{err: failed}))
+	if !errors.Is(err, failed) {
+		t.Errorf("ToADF returned %v, want %v", err, failed)
+	}
+}
+
+func TestImageDestinations(t *testing.T) {
+	chart := writeImage(t, "chart.png")
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	relative, err := filepath.Rel(wd, chart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		destination string
+		wiki        string // The wiki markup the image is written as
+		uploaded    bool
+	}{
+		{name: "absolute path", destination: chart, wiki: "!chart.png!", uploaded: true},
+		{name: "relative path", destination: relative, wiki: "!chart.png!", uploaded: true},
+		{name: "file URL", destination: "file://" + filepath.ToSlash(chart), wiki: "!chart.png!", uploaded: true},
+		{name: "missing file", destination: "missing.png", wiki: "alt"},
+		{name: "attachment", destination: "/secure/attachment/10000/build%20log.png", wiki: "!build log.png!"},
+		{name: "site image", destination: "/images/icons/link.png", wiki: "!link.png!"},
+		{name: "remote image", destination: "https://example.com/a.png", wiki: "!https://example.com/a.png!"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			markdown := []byte("![alt](" + test.destination + ")")
+
+			uploader := &recordingUploader{}
+			markup, err := ToWiki(markdown, WithMediaUploader(uploader))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if markup != test.wiki {
+				t.Errorf("ToWiki(%q) = %q, want %q", markdown, markup, test.wiki)
+			}
+			if uploaded := len(uploader.paths) > 0; uploaded != test.uploaded {
+				t.Errorf("ToWiki(%q) uploaded %q, want uploaded %v", markdown, uploader.paths, test.uploaded)
+			}
+
+			uploader = &recordingUploader{}
+			if _, err := ToADF(markdown, WithMediaUploader(uploader)); err != nil {
+				t.Fatal(err)
+			}
+			if uploaded := len(uploader.paths) > 0; uploaded != test.uploaded {
+				t.Errorf("ToADF(%q) uploaded %q, want uploaded %v", markdown, uploader.paths, test.uploaded)
+			}
+		})
+	}
+}
+
+func TestValidateMediaCollection(t *testing.T) {
+	tests := []struct {
+		name  string
+		media string
+		valid bool
+	}{
+		{name: "file with collection", media: `{"type":"file","id":"abc","collection":""}`, valid: true},
+		{name: "file without collection", media: `{"type":"file","id":"abc"}`, valid: false},
+		{name: "external", media: `{"type":"external","url":"https://example.com/a.png"}`, valid: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			doc := new(Node)
+			raw := `{"type":"doc","version":1,"content":[{"type":"mediaSingle","attrs":{"layout":"center"},` +
+				`"content":[{"type":"media","attrs":` + test.media + `}]}]}`
+			if err := json.Unmarshal([]byte(raw), doc); err != nil {
+				t.Fatal(err)
+			}
+			if err := Validate(doc); (err == nil) != test.valid {
+				t.Errorf("Validate(%s) = %v, want valid %v", test.media, err, test.valid)
+			}
+		})
+	}
+}
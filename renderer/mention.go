@@ -0,0 +1,136 @@
+package renderer
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+// MentionResolver looks up the user a mention refers to.
+// The query is either a username such as jane.doe or a display name such as Jane Doe.
+type MentionResolver interface {
+	ResolveMention(query string) (accountID string, displayName string, err error)
+}
+
+// WithMentionResolver resolves @mentions into ADF mention nodes.
+// Without a resolver mentions are kept as plain text.
+func WithMentionResolver(resolver MentionResolver) Option {
+	return func(r *ADFRenderer) {
+		r.mentions = resolver
+	}
+}
+
+// KindMention is a NodeKind of the Mention node.
+var KindMention = ast.NewNodeKind("Mention")
+
+// Mention is an inline node for @username, @[Display Name] and @[Display Name|account-id].
+// When the account ID is already known the mention does not need to be resolved.
+type Mention struct {
+	ast.BaseInline
+	Query     string
+	AccountID string
+	Offset    int // Position of the mention in the source, for error reporting
+}
+
+// Kind implements ast.Node.Kind.
+func (n *Mention) Kind() ast.NodeKind {
+	return KindMention
+}
+
+// Dump implements ast.Node.Dump.
+func (n *Mention) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Query": n.Query, "AccountID": n.AccountID}, nil)
+}
+
+type mentionParser struct{}
+
+func (p *mentionParser) Trigger() []byte {
+	return []byte{'@'}
+}
+
+func (p *mentionParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	// Keep email addresses as text
+	if previous := block.PrecendingCharacter(); isWordRune(previous) || previous == '.' {
+		return nil
+	}
+
+	line, segment := block.PeekLine()
+	node := &Mention{Offset: segment.Start}
+
+	if bytes.HasPrefix(line, []byte("@[")) {
+		end := bytes.IndexByte(line, ']')
+		if end < 0 {
+			return nil
+		}
+		// Pipes are escaped when the mention is inside of a table
+		name := strings.ReplaceAll(string(line[2:end]), `\|`, "|")
+		if i := strings.LastIndex(name, "|"); i >= 0 {
+			node.AccountID = strings.TrimSpace(name[i+1:])
+			name = name[:i]
+		}
+		node.Query = strings.TrimSpace(name)
+		if node.Query == "" {
+			return nil
+		}
+		block.Advance(end + 1)
+		return node
+	}
+
+	end := 1
+	for end < len(line) && isUsernameRune(rune(line[end])) {
+		end++
+	}
+	// A trailing dot ends the sentence rather than the username
+	for end > 1 && line[end-1] == '.' {
+		end--
+	}
+	if end == 1 {
+		return nil
+	}
+	node.Query = string(line[1:end])
+	block.Advance(end)
+	return node
+}
+
+func isUsernameRune(r rune) bool {
+	return r < unicode.MaxASCII && (isWordRune(r) || r == '.' || r == '_' || r == '-')
+}
+
+// mention converts a Mention into an ADF mention node, or plain text when there is no resolver
+func (r *renderState) mention(source []byte, n *Mention) (*Node, error) {
+	accountID, displayName := n.AccountID, n.Query
+	if accountID == "" {
+		if r.mentions == nil {
+			return &Node{Type: NodeTypeText, Text: "@" + n.Query}, nil
+		}
+		var err error
+		accountID, displayName, err = r.mentions.ResolveMention(n.Query)
+		if err != nil {
+			line, column := position(source, n.Offset)
+			return nil, fmt.Errorf("mention @%s at line %d, column %d: %w", n.Query, line, column, err)
+		}
+	}
+
+	return &Node{
+		Type: NodeTypeMention,
+		Attributes: &Attributes{
+			ID:   accountID,
+			Text: "@" + displayName,
+		},
+	}, nil
+}
+
+// mentionMarkdown writes a mention with its account ID so it does not need to be resolved again
+func (w *markdownWriter) mentionMarkdown(n *Node) string {
+	name := strings.ReplaceAll(strings.TrimPrefix(n.Attributes.Text, "@"), "]", "")
+	mention := "@[" + name + "|" + n.Attributes.ID + "]"
+	if w.inTable {
+		return strings.ReplaceAll(mention, "|", `\|`)
+	}
+	return mention
+}
@@ -0,0 +1,63 @@
+package renderer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// directory resolves mentions from a fixed set of users, keyed by username and display name
+type directory map[string]string
+
+func (d directory) ResolveMention(query string) (string, string, error) {
+	for name, accountID := range d {
+		if strings.EqualFold(name, query) {
+			return accountID, name, nil
+		}
+	}
+	return "", "", fmt.Errorf("No Jira user matches %q", query)
+}
+
+// team is the directory the golden mentions are resolved with
+var team = directory{
+	"jane.doe": "5b10ac8d82e05b22cc7d4ef5",
+	"Jane Doe": "5b10ac8d82e05b22cc7d4ef5",
+	"john":     "5b10a2844c20165700ede21g",
+}
+
+func TestMentionWithoutResolver(t *testing.T) {
+	doc, err := ToADF([]byte("Thanks @jane.doe and @[Jane Doe]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if countNodes(t, doc, NodeTypeMention) != 0 {
+		t.Errorf("Want no mention nodes without a resolver, got %+v", doc.Content)
+	}
+	want := paragraphDoc(textJSON("Thanks @jane.doe and @Jane Doe"))
+	if !sameDocument(t, doc, want) {
+		t.Errorf("Want the mentions kept as text")
+	}
+}
+
+func TestMentionResolveError(t *testing.T) {
+	ambiguous := errors.New(`"jane" matches more than one Jira user`)
+	resolver := failingResolver{err: ambiguous}
+
+	_, err := ToADF([]byte("First line\n\nThen @jane please"), WithMentionResolver(resolver))
+	if !errors.Is(err, ambiguous) {
+		t.Fatalf("ToADF returned %v, want %v", err, ambiguous)
+	}
+	if !strings.Contains(err.Error(), "@jane at line 3, column 6") {
+		t.Errorf("Error %q does not say where the mention is", err)
+	}
+}
+
+// failingResolver fails to resolve any mention
+type failingResolver struct {
+	err error
+}
+
+func (f failingResolver) ResolveMention(string) (string, string, error) {
+	return "", "", f.err
+}
@@ -0,0 +1,168 @@
+package renderer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+// ADF nodes that markdown cannot express are written as placeholders holding their original JSON, and are
+// expanded back verbatim by the ADFRenderer:
+//
+// Block nodes use a fenced code block with the "adf" language.
+//
+//	```adf
+//	{"type": "mediaSingle", ...}
+//	```
+//
+// Inline nodes use an inline token on a single line, {adf:{"type": "date", ...}}.
+const (
+	placeholderLanguage    = "adf"
+	inlinePlaceholderStart = "{adf:"
+)
+
+// KindADFPlaceholder is a NodeKind of the ADFPlaceholder node.
+var KindADFPlaceholder = ast.NewNodeKind("ADFPlaceholder")
+
+// ADFPlaceholder is an inline node holding the verbatim JSON of an ADF node.
+type ADFPlaceholder struct {
+	ast.BaseInline
+	Raw    json.RawMessage
+	Offset int   // Position of the placeholder in the source, for error reporting
+	Err    error // Set when the placeholder does not hold a valid ADF node
+}
+
+// Kind implements ast.Node.Kind.
+func (n *ADFPlaceholder) Kind() ast.NodeKind {
+	return KindADFPlaceholder
+}
+
+// Dump implements ast.Node.Dump.
+func (n *ADFPlaceholder) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Raw": string(n.Raw)}, nil)
+}
+
+type placeholderParser struct{}
+
+func (p *placeholderParser) Trigger() []byte {
+	return []byte{'{'}
+}
+
+func (p *placeholderParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, segment := block.PeekLine()
+	if !bytes.HasPrefix(line, []byte(inlinePlaceholderStart)) {
+		return nil
+	}
+	node := &ADFPlaceholder{Offset: segment.Start}
+
+	rest := line[len(inlinePlaceholderStart):]
+	decoder := json.NewDecoder(bytes.NewReader(rest))
+	err := decoder.Decode(&node.Raw)
+	end := len(inlinePlaceholderStart) + int(decoder.InputOffset())
+	if err == nil && (end >= len(line) || line[end] != '}') {
+		err = errors.New("missing closing '}'")
+	}
+	if err == nil {
+		node.Raw, err = opaqueJSON(node.Raw)
+	}
+	if err != nil {
+		node.Err = err
+		// Consume the rest of the line, the placeholder is reported as an error when rendering
+		block.Advance(len(bytes.TrimRight(line, "\r\n")))
+		return node
+	}
+
+	block.Advance(end + 1)
+	return node
+}
+
+func isPlaceholderBlock(source []byte, n ast.Node) bool {
+	block, ok := n.(*ast.FencedCodeBlock)
+	return ok && string(block.Language(source)) == placeholderLanguage
+}
+
+// opaqueJSON validates that raw holds a single ADF node and returns it compacted
+func opaqueJSON(raw []byte) (json.RawMessage, error) {
+	var node struct {
+		Type NodeType `json:"type"`
+	}
+	if err := json.Unmarshal(raw, &node); err != nil {
+		return nil, err
+	}
+	if node.Type == "" {
+		return nil, errors.New(`missing "type"`)
+	}
+
+	compact := new(bytes.Buffer)
+	if err := json.Compact(compact, raw); err != nil {
+		return nil, err
+	}
+	return compact.Bytes(), nil
+}
+
+// opaqueNode creates a node that is written back exactly as raw
+func opaqueNode(raw json.RawMessage) *Node {
+	node := &Node{raw: raw, verbatim: true}
+	// Only the type is needed by the renderer, the attributes and content stay untouched in raw
+	_ = json.Unmarshal(raw, &struct {
+		Type *NodeType `json:"type"`
+	}{&node.Type})
+	return node
+}
+
+// originalJSON prefers the JSON a node was parsed from so attributes unknown to Node are kept
+func originalJSON(n *Node) ([]byte, error) {
+	if n.raw != nil {
+		return n.raw, nil
+	}
+	return json.Marshal(n)
+}
+
+func blockPlaceholder(n *Node) (string, error) {
+	raw, err := originalJSON(n)
+	if err != nil {
+		return "", err
+	}
+	indented := new(bytes.Buffer)
+	if err := json.Indent(indented, raw, "", "  "); err != nil {
+		return "", err
+	}
+	return codeFence(indented.String(), placeholderLanguage), nil
+}
+
+func (w *markdownWriter) inlinePlaceholder(n *Node) (string, error) {
+	raw, err := originalJSON(n)
+	if err != nil {
+		return "", err
+	}
+	compact := new(bytes.Buffer)
+	if err := json.Compact(compact, raw); err != nil {
+		return "", err
+	}
+	raw = compact.Bytes()
+	if w.inTable {
+		// Pipes can only appear inside JSON strings, where the escaped form is equivalent
+		raw = bytes.ReplaceAll(raw, []byte("|"), []byte(`\u007c`))
+	}
+	return inlinePlaceholderStart + string(raw) + "}", nil
+}
+
+// position converts a byte offset in source into a 1-based line and column
+func position(source []byte, offset int) (line, column int) {
+	if offset > len(source) {
+		offset = len(source)
+	}
+	line = bytes.Count(source[:offset], []byte("\n")) + 1
+	column = offset - bytes.LastIndexByte(source[:offset], '\n')
+	return line, column
+}
+
+func placeholderError(source []byte, offset int, err error) error {
+	line, column := position(source, offset)
+	return fmt.Errorf("invalid adf placeholder at line %d, column %d: %w", line, column, err)
+}
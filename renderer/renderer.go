@@ -1,23 +1,68 @@
 package renderer
 
 import (
+	"bytes"
 	"encoding/json"
 	"io"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode"
 
 	"github.com/yuin/goldmark"
+	emoji "github.com/yuin/goldmark-emoji"
+	emojiAst "github.com/yuin/goldmark-emoji/ast"
 	"github.com/yuin/goldmark/ast"
 	"github.com/yuin/goldmark/extension"
 	extAst "github.com/yuin/goldmark/extension/ast"
 	"github.com/yuin/goldmark/parser"
 	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
 )
 
 var _ renderer.Renderer = &ADFRenderer{}
 
-// ADFRenderer implements goldmark.Renderer
+// lineBreakTag matches <br>, the one HTML tag with an ADF equivalent
+var lineBreakTag = regexp.MustCompile(`(?i)^<br\s*/?>$`)
+
+// ADFRenderer implements goldmark.Renderer.
+// It only holds options, every document is rendered with its own state so a renderer can be reused and shared
+// between goroutines, as long as the mention resolver, media uploader and warning callback are safe to share too.
 type ADFRenderer struct {
+	mentions   MentionResolver
+	smartLinks *SmartLinks
+	media      MediaUploader
+	strict     bool
+	warnings   func(Unsupported)
+	compact    bool
+	original   *Node         // The document a markdown edit started from
+	newLocalID func() string // Generates local IDs, random ones when nil
+}
+
+// renderState is the state of rendering a single document
+type renderState struct {
+	*ADFRenderer
 	document *Node          // Root node
 	context  blockNodeStack // Track where we are in the structure of the document
+	marks    []MarkStruct   // Marks of the inline nodes we are in, applied to every text node below them
+	errors   []Unsupported  // Constructs that were degraded in strict mode
+	uploads  []upload       // Local images, uploaded once the document is accepted
+	source   []byte
+}
+
+// Option configures an ADFRenderer.
+// Options also implement goldmark's renderer.Option, so they can be passed to goldmark.WithRendererOptions.
+type Option func(*ADFRenderer)
+
+// SetConfig implements renderer.Option, the option is applied by ADFRenderer.AddOptions instead
+func (o Option) SetConfig(*renderer.Config) {}
+
+// WithCompactJSON makes Render write JSON without indentation.
+func WithCompactJSON() Option {
+	return func(r *ADFRenderer) {
+		r.compact = true
+	}
 }
 
 type Node struct {
@@ -27,6 +72,26 @@ type Node struct {
 	Content    []*Node      `json:"content,omitempty"`
 	Marks      []MarkStruct `json:"marks,omitempty"`
 	Text       string       `json:"text,omitempty"`
+
+	raw      json.RawMessage // JSON the node was parsed from
+	verbatim bool            // Write raw back instead of the fields above, for nodes markdown cannot express
+}
+
+func (n *Node) MarshalJSON() ([]byte, error) {
+	if n.verbatim {
+		return n.raw, nil
+	}
+	type plain Node
+	return json.Marshal((*plain)(n))
+}
+
+func (n *Node) UnmarshalJSON(data []byte) error {
+	type plain Node
+	if err := json.Unmarshal(data, (*plain)(n)); err != nil {
+		return err
+	}
+	n.raw = append(json.RawMessage(nil), data...)
+	return nil
 }
 
 func (n *Node) AddContent(c *Node) {
@@ -34,10 +99,35 @@ func (n *Node) AddContent(c *Node) {
 }
 
 type Attributes struct {
-	Width    float32 `json:"width,omitempty"`    // For media single
-	Layout   Layout  `json:"layout,omitempty"`   // For media single
-	Level    int     `json:"level,omitempty"`    // For headings
-	Language string  `json:"language,omitempty"` // For fenced code blocks
+	Width     float32   `json:"width,omitempty"`     // For media single, and media in pixels
+	Layout    Layout    `json:"layout,omitempty"`    // For media single and tables
+	Level     int       `json:"level,omitempty"`     // For headings
+	Language  string    `json:"language,omitempty"`  // For fenced code blocks
+	PanelType PanelType `json:"panelType,omitempty"` // For panels
+	Order     int       `json:"order,omitempty"`     // For ordered lists
+
+	Colspan    int       `json:"colspan,omitempty"`    // For table cells
+	Rowspan    int       `json:"rowspan,omitempty"`    // For table cells
+	Colwidth   []float64 `json:"colwidth,omitempty"`   // For table cells
+	Background string    `json:"background,omitempty"` // For table cells
+
+	ID        string `json:"id,omitempty"`        // For mentions, the account ID, emoji and media
+	ShortName string `json:"shortName,omitempty"` // For emoji, e.g. :tada:
+	Text      string `json:"text,omitempty"`      // For mentions, emoji and status lozenges
+	Color     string `json:"color,omitempty"`     // For status lozenges
+	URL       string `json:"url,omitempty"`       // For inline cards
+	Timestamp string `json:"timestamp,omitempty"` // For dates, in milliseconds since the epoch
+	LocalID   string `json:"localId,omitempty"`   // For task lists and task items
+	State     string `json:"state,omitempty"`     // For task items, either TODO or DONE
+
+	Type       string  `json:"type,omitempty"`       // For media, either file or external
+	Collection *string `json:"collection,omitempty"` // For media files, Jira requires it even when empty
+	Alt        string  `json:"alt,omitempty"`        // For media
+	Height     float32 `json:"height,omitempty"`     // For media in pixels
+
+	Title string `json:"title,omitempty"` // For expands
+
+	IsNumberColumnEnabled bool `json:"isNumberColumnEnabled,omitempty"` // For tables
 }
 
 type MarkStruct struct {
@@ -48,6 +138,9 @@ type MarkStruct struct {
 type MarkAttributes struct {
 	Href  string `json:"href,omitempty"`  // For links
 	Title string `json:"title,omitempty"` // For links
+	Align string `json:"align,omitempty"` // For alignment, either "center" or "end"
+	Type  string `json:"type,omitempty"`  // For subsup, either "sub" or "sup"
+	Color string `json:"color,omitempty"` // For text color, as #rrggbb
 }
 
 // Type represents the type of a node
@@ -55,33 +148,41 @@ type NodeType string
 
 // Node types
 const (
-	NodeTypeNone        = "none"
-	NodeTypeBlockquote  = "blockquote"
-	NodeTypeBulletList  = "bulletList"
-	NodeTypeCodeBlock   = "codeBlock"
-	NodeTypeHeading     = "heading"
-	NodeTypeMediaGroup  = "mediaGroup"
-	NodeTypeMediaSingle = "mediaSingle"
-	NodeTypeOrderedList = "orderedList"
-	NodeTypePanel       = "panel"
-	NodeTypeParagraph   = "paragraph"
-	NodeTypeRule        = "rule"
-	NodeTypeTable       = "table"
-	NodeTypeListItem    = "listItem"
-	NodeTypeMedia       = "media"
-	NodeTypeTableCell   = "table_cell"
-	NodeTypeTableHeader = "table_header"
-	NodeTypeTableRow    = "table_row"
-	NodeTypeEmoji       = "emoji"
-	NodeTypeHardBreak   = "hardBreak"
-	NodeTypeInlineCard  = "inlineCard"
-	NodeTypeMention     = "mention"
-	NodeTypeText        = "text"
+	NodeTypeNone         = "none"
+	NodeTypeDoc          = "doc"
+	NodeTypeBlockquote   = "blockquote"
+	NodeTypeBulletList   = "bulletList"
+	NodeTypeCodeBlock    = "codeBlock"
+	NodeTypeHeading      = "heading"
+	NodeTypeMediaGroup   = "mediaGroup"
+	NodeTypeMediaSingle  = "mediaSingle"
+	NodeTypeOrderedList  = "orderedList"
+	NodeTypePanel        = "panel"
+	NodeTypeParagraph    = "paragraph"
+	NodeTypeRule         = "rule"
+	NodeTypeTable        = "table"
+	NodeTypeListItem     = "listItem"
+	NodeTypeMedia        = "media"
+	NodeTypeTableCell    = "tableCell"
+	NodeTypeTableHeader  = "tableHeader"
+	NodeTypeTableRow     = "tableRow"
+	NodeTypeEmoji        = "emoji"
+	NodeTypeHardBreak    = "hardBreak"
+	NodeTypeInlineCard   = "inlineCard"
+	NodeTypeMention      = "mention"
+	NodeTypeStatus       = "status"
+	NodeTypeDate         = "date"
+	NodeTypeTaskList     = "taskList"
+	NodeTypeTaskItem     = "taskItem"
+	NodeTypeExpand       = "expand"
+	NodeTypeNestedExpand = "nestedExpand"
+	NodeTypeText         = "text"
 )
 
 func inlineType(t NodeType) bool {
 	switch t {
-	case NodeTypeEmoji, NodeTypeHardBreak, NodeTypeInlineCard, NodeTypeMention, NodeTypeText:
+	case NodeTypeEmoji, NodeTypeHardBreak, NodeTypeInlineCard, NodeTypeMention, NodeTypeText,
+		NodeTypeStatus, NodeTypeDate:
 		return true
 	default:
 		return false
@@ -99,64 +200,217 @@ const (
 	LayoutFullWidth  = "full-width"
 	LayoutAlignStart = "align-start"
 	LayoutAlignEnd   = "align-end"
+	LayoutDefault    = "default"
 )
 
+// allowedContent lists the block nodes ADF allows inside of a block node. Block nodes that are not listed
+// may contain any block node.
+var allowedContent = map[NodeType][]NodeType{
+	NodeTypeBlockquote: {NodeTypeParagraph, NodeTypeBulletList, NodeTypeOrderedList, NodeTypeCodeBlock,
+		NodeTypeMediaGroup, NodeTypeMediaSingle},
+	NodeTypePanel: {NodeTypeParagraph, NodeTypeHeading, NodeTypeBulletList, NodeTypeOrderedList, NodeTypeCodeBlock,
+		NodeTypeMediaGroup, NodeTypeMediaSingle, NodeTypeRule, NodeTypeTaskList},
+	NodeTypeListItem: {NodeTypeParagraph, NodeTypeBulletList, NodeTypeOrderedList, NodeTypeCodeBlock,
+		NodeTypeMediaSingle},
+	NodeTypeTableHeader: {NodeTypeParagraph, NodeTypePanel, NodeTypeBlockquote, NodeTypeBulletList,
+		NodeTypeOrderedList, NodeTypeRule, NodeTypeHeading, NodeTypeCodeBlock, NodeTypeMediaGroup, NodeTypeMediaSingle,
+		NodeTypeTaskList, NodeTypeNestedExpand},
+	NodeTypeTableCell: {NodeTypeParagraph, NodeTypePanel, NodeTypeBlockquote, NodeTypeBulletList,
+		NodeTypeOrderedList, NodeTypeRule, NodeTypeHeading, NodeTypeCodeBlock, NodeTypeMediaGroup, NodeTypeMediaSingle,
+		NodeTypeTaskList, NodeTypeNestedExpand},
+	NodeTypeExpand: {NodeTypeParagraph, NodeTypePanel, NodeTypeBlockquote, NodeTypeOrderedList, NodeTypeBulletList,
+		NodeTypeRule, NodeTypeHeading, NodeTypeCodeBlock, NodeTypeMediaGroup, NodeTypeMediaSingle, NodeTypeTaskList,
+		NodeTypeTable, NodeTypeNestedExpand},
+	NodeTypeNestedExpand: {NodeTypeParagraph, NodeTypeHeading, NodeTypeMediaGroup, NodeTypeMediaSingle,
+		NodeTypeCodeBlock, NodeTypeBulletList, NodeTypeOrderedList, NodeTypeTaskList, NodeTypeRule, NodeTypePanel,
+		NodeTypeBlockquote},
+	NodeTypeTaskList: {NodeTypeTaskItem, NodeTypeTaskList},
+
+	// Text blocks only hold inline content
+	NodeTypeParagraph: {},
+	NodeTypeHeading:   {},
+	NodeTypeTaskItem:  {},
+}
+
+func allowedIn(parent, child NodeType) bool {
+	allowed, ok := allowedContent[parent]
+	if !ok {
+		return true
+	}
+	for _, t := range allowed {
+		if t == child {
+			return true
+		}
+	}
+	return false
+}
+
+type blockFrame struct {
+	node      *Node
+	origin    ast.Node // The markdown node the block was pushed for
+	dropped   bool     // ADF does not support some forms of nesting that markdown does, the content of a dropped block goes to its parent
+	continued bool     // The paragraph continues one that was split by a block node
+	converted bool     // The block was turned into a paragraph, the blocks it held are expected to be flattened
+}
+
 type blockNodeStack struct {
-	data          []*Node
-	ignoreBlocks  bool    // ADF does not support some forms of nesting that markdown does, so we sometimes ignore non-paragraph block nodes
-	ignoredBlocks []*Node // Contains the root block and its children when a block node can only contain content
+	data   []blockFrame
+	origin ast.Node                                          // The markdown node being rendered
+	report func(origin ast.Node, construct, fallback string) // Called when a block is flattened to fit ADF
 }
 
 func (s *blockNodeStack) PushContent(node *Node) {
 	s.PeekBlockNode().AddContent(node)
 }
 
+// PushText adds a text node to the current block, merging it into the previous text node when their marks match
+func (s *blockNodeStack) PushText(node *Node) {
+	block := s.PeekBlockNode()
+	if len(block.Content) > 0 {
+		last := block.Content[len(block.Content)-1]
+		if last.Type == NodeTypeText && !last.verbatim && reflect.DeepEqual(last.Marks, node.Marks) {
+			last.Text += node.Text
+			return
+		}
+	}
+	block.AddContent(node)
+}
+
 func (s *blockNodeStack) PushBlockNode(node *Node) {
-	if s.ignoreBlocks {
-		s.ignoredBlocks = append(s.ignoredBlocks, node)
+	parent := s.PeekBlockNode()
+	if parent.Type == NodeTypeTaskItem && node.Type == NodeTypeTaskList {
+		// Nested task lists follow the item they are nested under in its task list
+		s.parentTaskList().AddContent(node)
+		s.data = append(s.data, blockFrame{node: node, origin: s.origin})
+		return
+	}
+	if !allowedIn(parent.Type, node.Type) {
+		// Keep text blocks such as headings as paragraphs where possible, drop the block but keep its content otherwise
+		convert := (node.Type == NodeTypeHeading || node.Type == NodeTypeTaskItem) &&
+			allowedIn(parent.Type, NodeTypeParagraph)
 
-		// Paragraphs are the only block node type that can still be added
-		if node.Type != NodeTypeParagraph {
+		// Blocks inside of a flattened block were already reported with it
+		if top := s.data[len(s.data)-1]; s.report != nil && !top.dropped && !top.converted {
+			fallback := "its content"
+			if convert {
+				fallback = "a paragraph"
+			}
+			s.report(s.origin, string(node.Type)+" inside of "+string(parent.Type), fallback)
+		}
+
+		if !convert {
+			s.DropBlockNode(node)
 			return
 		}
+		if node.Type == NodeTypeTaskItem {
+			checkBox := "[ ] "
+			if node.Attributes.State == TaskStateDone {
+				checkBox = "[x] "
+			}
+			node.Content = []*Node{{Type: NodeTypeText, Text: checkBox}}
+		}
+		node.Type = NodeTypeParagraph
+		node.Attributes = nil
+		s.PushContent(node)
+		s.data = append(s.data, blockFrame{node: node, origin: s.origin, converted: true})
+		return
 	}
 
 	// Update the actual document
 	s.PushContent(node)
 	// Update the context stack
-	s.data = append(s.data, node)
+	s.data = append(s.data, blockFrame{node: node, origin: s.origin})
+}
+
+// DropBlockNode pushes a block that is left out of the document, its content goes to its parent instead
+func (s *blockNodeStack) DropBlockNode(node *Node) {
+	s.data = append(s.data, blockFrame{node: node, origin: s.origin, dropped: true})
 }
 
 // Intentionally unsafe because we should never peek an empty stack
 func (s *blockNodeStack) PeekBlockNode() *Node {
-	return s.data[len(s.data)-1]
+	for i := len(s.data) - 1; i > 0; i-- {
+		if !s.data[i].dropped {
+			return s.data[i].node
+		}
+	}
+	return s.data[0].node
 }
 
-// Intentionally unsafe because we should never pop an empty stack
-func (s *blockNodeStack) PopBlockNode() *Node {
-	last := len(s.data) - 1
-	node := s.data[last]
-
-	if s.ignoreBlocks {
-		s.ignoredBlocks = s.ignoredBlocks[:len(s.ignoredBlocks)-1]
-		if len(s.ignoredBlocks) == 0 {
-			s.ignoreBlocks = false
-		} else if node.Type != NodeTypeParagraph {
-			return node
+// parentTaskList finds the task list holding the task item at the top of the stack
+func (s *blockNodeStack) parentTaskList() *Node {
+	for i := len(s.data) - 1; i >= 0; i-- {
+		if !s.data[i].dropped && s.data[i].node.Type == NodeTypeTaskList {
+			return s.data[i].node
 		}
 	}
+	return s.data[0].node
+}
 
+// BreakParagraph places a block node after the paragraph at the top of the stack, the rest of the paragraph
+// continues in a new paragraph after it. It reports false when the block cannot be placed there.
+func (s *blockNodeStack) BreakParagraph(node *Node) bool {
+	last := len(s.data) - 1
+	frame := s.data[last]
+	if last == 0 || frame.dropped || frame.node.Type != NodeTypeParagraph {
+		return false
+	}
 	s.data = s.data[:last]
-	return node
+	parent := s.PeekBlockNode()
+	if !allowedIn(parent.Type, node.Type) {
+		s.data = append(s.data, frame)
+		return false
+	}
+
+	s.endParagraph(parent, frame)
+	parent.AddContent(node)
+	rest := &Node{Type: NodeTypeParagraph, Marks: frame.node.Marks}
+	parent.AddContent(rest)
+	s.data = append(s.data, blockFrame{node: rest, origin: frame.origin, continued: true})
+	return true
 }
 
-func (s *blockNodeStack) IgnoreNestedBlocks(node *Node) {
-	if s.ignoreBlocks {
-		return
+// endParagraph trims the whitespace left at a split and removes paragraphs the split left empty
+func (s *blockNodeStack) endParagraph(parent *Node, frame blockFrame) {
+	paragraph := frame.node
+	for len(paragraph.Content) > 0 {
+		last := paragraph.Content[len(paragraph.Content)-1]
+		if last.Type == NodeTypeText && !last.verbatim {
+			if last.Text = strings.TrimRightFunc(last.Text, unicode.IsSpace); last.Text != "" {
+				break
+			}
+		} else if last.Type != NodeTypeHardBreak {
+			break
+		}
+		paragraph.Content = paragraph.Content[:len(paragraph.Content)-1]
+	}
+	for frame.continued && len(paragraph.Content) > 0 {
+		first := paragraph.Content[0]
+		if first.Type == NodeTypeText && !first.verbatim {
+			if first.Text = strings.TrimLeftFunc(first.Text, unicode.IsSpace); first.Text != "" {
+				break
+			}
+		} else if first.Type != NodeTypeHardBreak {
+			break
+		}
+		paragraph.Content = paragraph.Content[1:]
 	}
 
-	s.ignoreBlocks = true
-	s.ignoredBlocks = append(s.ignoredBlocks, node)
+	if len(paragraph.Content) == 0 && len(parent.Content) > 0 && parent.Content[len(parent.Content)-1] == paragraph {
+		parent.Content = parent.Content[:len(parent.Content)-1]
+	}
+}
+
+// PopBlockNodes pops the blocks pushed for a markdown node, which is none for inline nodes and up to two for table
+// cells holding a paragraph
+func (s *blockNodeStack) PopBlockNodes(origin ast.Node) {
+	for last := len(s.data) - 1; last > 0 && s.data[last].origin == origin; last-- {
+		frame := s.data[last]
+		s.data = s.data[:last]
+		if frame.continued {
+			s.endParagraph(s.PeekBlockNode(), frame)
+		}
+	}
 }
 
 // Mark represents a text formatting directive
@@ -164,6 +418,7 @@ type Mark string
 
 // Enum values for Mark text formatting
 const (
+	MarkAlignment Mark = "alignment"
 	MarkCode      Mark = "code"
 	MarkEm        Mark = "em"
 	MarkLink      Mark = "link"
@@ -174,31 +429,79 @@ const (
 	MarkUnderline Mark = "underline"
 )
 
-func NewRenderer() *ADFRenderer {
+func NewRenderer(opts ...Option) *ADFRenderer {
+	r := &ADFRenderer{}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+func newRenderState(r *ADFRenderer, source []byte) *renderState {
 	root := Node{
 		Version: 1,
-		Type:    "doc",
+		Type:    NodeTypeDoc,
 	}
-	return &ADFRenderer{
-		document: &root,
+	state := &renderState{
+		ADFRenderer: r,
+		document:    &root,
 		context: blockNodeStack{
-			data: []*Node{&root},
+			data: []blockFrame{{node: &root}},
 		},
+		source: source,
 	}
+	state.context.report = func(origin ast.Node, construct, fallback string) {
+		state.unsupported(origin, construct, fallback)
+	}
+	return state
+}
+
+// Render converts markdown into an ADF document and writes it as JSON.
+func Render(w io.Writer, source []byte, opts ...Option) error {
+	return NewRenderer(opts...).markdown().Convert(source, w)
+}
+
+// ToADF converts markdown into an ADF document.
+func ToADF(source []byte, opts ...Option) (*Node, error) {
+	return NewRenderer(opts...).ToADF(source)
 }
 
-func Render(w io.Writer, source []byte) error {
-	gm := goldmark.New(
+// ToADF converts markdown into an ADF document with the options of the renderer.
+func (r *ADFRenderer) ToADF(source []byte) (*Node, error) {
+	document := r.markdown().Parser().Parse(text.NewReader(source))
+	return r.convert(source, document)
+}
+
+// markdown sets up goldmark with the markdown extensions the renderer understands
+func (r *ADFRenderer) markdown() goldmark.Markdown {
+	return newMarkdown(r)
+}
+
+// newMarkdown sets up goldmark with the markdown extensions of this package, rendered by r
+func newMarkdown(r renderer.Renderer) goldmark.Markdown {
+	return goldmark.New(
 		goldmark.WithExtensions(
 			extension.GFM, // GitHub flavoured markdown.
+			emoji.Emoji,   // Enables :shortcode: emoji.
 		),
 		goldmark.WithParserOptions(
 			parser.WithAttribute(), // Enables # headers {#custom-ids}.
+			parser.WithInlineParsers(
+				util.Prioritized(&placeholderParser{}, 100), // Enables {adf:{...}} placeholders for untouched nodes.
+				util.Prioritized(&mentionParser{}, 100),     // Enables @username and @[Display Name] mentions.
+				util.Prioritized(&braceParser{}, 100),       // Enables {status:...}, {date:...} and {color:...}.
+				util.Prioritized(underlineDelimiter, 100),   // Enables ++underline++.
+				util.Prioritized(subscriptDelimiter, 100),   // Enables ~subscript~.
+				util.Prioritized(superscriptDelimiter, 100), // Enables ^superscript^.
+			),
+			parser.WithASTTransformers(
+				util.Prioritized(&admonitionTransformer{}, 100), // Enables > [!NOTE] panels.
+				util.Prioritized(&detailsTransformer{}, 100),    // Enables <details> expands.
+				util.Prioritized(&textColorTransformer{}, 100),  // Pairs {color:...} and {color}.
+			),
 		),
-		goldmark.WithRenderer(NewRenderer()),
+		goldmark.WithRenderer(r),
 	)
-
-	return gm.Convert(source, w)
 }
 
 func astToADFType(n ast.Node) NodeType {
@@ -209,8 +512,13 @@ func astToADFType(n ast.Node) NodeType {
 		return NodeTypeParagraph
 	case *ast.Heading:
 		return NodeTypeHeading
+	case *emojiAst.Emoji:
+		return NodeTypeEmoji
 	case *ast.Text,
 		*ast.String,
+		*ADFPlaceholder,
+		*Mention,
+		*ast.AutoLink,
 		*extAst.Strikethrough,
 		*ast.Emphasis,
 		*ast.CodeSpan,
@@ -223,39 +531,54 @@ func astToADFType(n ast.Node) NodeType {
 		return NodeTypeRule
 	case *ast.Blockquote:
 		return NodeTypeBlockquote
+	case *Admonition:
+		return NodeTypePanel
+	case *Details:
+		return NodeTypeExpand
 	case *ast.List:
+		if isTaskList(n) {
+			return NodeTypeTaskList
+		}
 		if n.(*ast.List).IsOrdered() {
 			return NodeTypeOrderedList
 		}
 		return NodeTypeBulletList
 	case *ast.ListItem:
+		if isTaskList(n.Parent()) {
+			return NodeTypeTaskItem
+		}
 		return NodeTypeListItem
+	case *extAst.TaskCheckBox:
+		return NodeTypeText
 	case *ast.Image:
-		return NodeTypeMedia
+		return NodeTypeMediaSingle
 	case *ast.HTMLBlock:
 	case *ast.RawHTML:
 	case *extAst.Table:
 		return NodeTypeTable
-	case *extAst.TableHeader:
-		return NodeTypeTableHeader
-	case *extAst.TableRow:
+	case *extAst.TableHeader,
+		*extAst.TableRow:
 		return NodeTypeTableRow
 	case *extAst.TableCell:
+		if _, ok := n.Parent().(*extAst.TableHeader); ok {
+			return NodeTypeTableHeader
+		}
 		return NodeTypeTableCell
 	}
 
 	return NodeTypeNone
 }
 
-func (r *ADFRenderer) walkNode(source []byte, n ast.Node, entering bool) ast.WalkStatus {
-	// fmt.Printf("Node: %s, entering: %v, value: %q, children: %d\n", reflect.TypeOf(n).String(), entering, string(n.Text(source)), n.ChildCount())
-
+func (r *renderState) walkNode(source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
 	if !entering {
-		if !inlineType(astToADFType(n)) {
-			r.context.PopBlockNode()
+		r.context.PopBlockNodes(n)
+		switch n.(type) {
+		case *ast.Emphasis, *extAst.Strikethrough, *ast.Link, *Underline, *Subsup, *TextColor:
+			r.marks = r.marks[:len(r.marks)-1]
 		}
-		return ast.WalkContinue
+		return ast.WalkContinue, nil
 	}
+	r.context.origin = n
 
 	adfNode := &Node{Type: astToADFType(n)}
 
@@ -263,19 +586,85 @@ func (r *ADFRenderer) walkNode(source []byte, n ast.Node, entering bool) ast.Wal
 	case *ast.Document:
 		// Nothing to do, the root ADF node is fixed.
 
+	case *ast.List:
+		if block := r.context.PeekBlockNode(); block.Type == NodeTypeListItem && len(block.Content) == 0 {
+			// ADF list items start with a paragraph, not a nested list
+			block.AddContent(&Node{Type: NodeTypeParagraph})
+		}
+		if adfNode.Type == NodeTypeTaskList {
+			adfNode.Attributes = &Attributes{
+				LocalID: r.localID(),
+			}
+		} else if ntype.IsOrdered() && ntype.Start != 1 {
+			adfNode.Attributes = &Attributes{
+				Order: ntype.Start,
+			}
+		}
+		r.context.PushBlockNode(adfNode)
+
+	case *ast.ListItem:
+		if adfNode.Type == NodeTypeTaskItem {
+			adfNode.Attributes = &Attributes{
+				LocalID: r.localID(),
+				State:   taskState(ntype),
+			}
+		} else if !n.HasChildren() {
+			// ADF list items cannot be empty
+			adfNode.AddContent(&Node{Type: NodeTypeParagraph})
+		}
+		r.context.PushBlockNode(adfNode)
+
+	case *extAst.TaskCheckBox:
+		// The state of task items is set on the item, only checkboxes outside of task lists are kept as text
+		if !isTaskList(n.Parent().Parent().Parent()) {
+			r.unsupported(n.Parent(), "task list item in a list with other items", "text")
+			adfNode.Text = "[ ] "
+			if ntype.IsChecked {
+				adfNode.Text = "[x] "
+			}
+			adfNode.Marks = r.textMarks()
+			r.context.PushText(adfNode)
+		}
+
 	case *ast.Paragraph,
-		*ast.TextBlock, // Untested
-		*ast.List,
-		*ast.ListItem,
-		*ast.ThematicBreak,
-		*ast.CodeBlock: // Untested
+		*ast.TextBlock:
+		if !n.HasChildren() && r.context.PeekBlockNode().Type != NodeTypeListItem {
+			// Nothing is left of a paragraph of link reference definitions, list items still need one
+			return ast.WalkSkipChildren, nil
+		}
+		if block := r.context.PeekBlockNode(); block.Type == NodeTypeTaskItem {
+			// Task items only hold inline content, so every following paragraph starts on a new line
+			if len(block.Content) > 0 {
+				r.context.PushContent(&Node{Type: NodeTypeHardBreak})
+			}
+			r.context.DropBlockNode(adfNode)
+			break
+		}
+		r.context.PushBlockNode(adfNode)
+
+	case *ast.ThematicBreak:
 		r.context.PushBlockNode(adfNode)
 
 	case *ast.Blockquote:
 		r.context.PushBlockNode(adfNode)
 
-		// ADF only supports paragraphs inside block quotes, no nested block quotes
-		r.context.IgnoreNestedBlocks(adfNode)
+	case *Admonition:
+		adfNode.Attributes = &Attributes{
+			PanelType: ntype.PanelType,
+		}
+		r.context.PushBlockNode(adfNode)
+
+	case *Details:
+		// Expands inside of other blocks are nested expands, which ADF only allows in some places
+		if r.context.PeekBlockNode().Type != NodeTypeDoc {
+			adfNode.Type = NodeTypeNestedExpand
+		}
+		if ntype.Title != "" {
+			adfNode.Attributes = &Attributes{
+				Title: ntype.Title,
+			}
+		}
+		r.context.PushBlockNode(adfNode)
 
 	case *ast.Heading:
 		adfNode.Attributes = &Attributes{
@@ -283,129 +672,373 @@ func (r *ADFRenderer) walkNode(source []byte, n ast.Node, entering bool) ast.Wal
 		}
 		r.context.PushBlockNode(adfNode)
 
-	case *ast.Text,
-		*ast.String: // Untested
-		adfNode.Text = string(n.Text(source))
-		if len(adfNode.Text) == 0 {
+	case *ast.Text:
+		if ntype.IsRaw() {
+			adfNode.Text = string(n.Text(source))
+		} else {
+			adfNode.Text = unescapedText(n.Text(source))
+		}
+		if ntype.SoftLineBreak() && !ntype.HardLineBreak() {
+			adfNode.Text += " "
+		}
+		if len(adfNode.Text) == 0 && !ntype.HardLineBreak() {
 			// TODO: Uh what's happening here? Not sure why goldmark is splitting up paragraph text in this way.
 			adfNode.Text = " "
 		}
-		r.context.PushContent(adfNode)
+		if len(adfNode.Text) > 0 {
+			adfNode.Marks = r.textMarks()
+			r.context.PushText(adfNode)
+		}
+		if ntype.HardLineBreak() {
+			r.context.PushContent(&Node{Type: NodeTypeHardBreak})
+		}
+
+	case *ast.String: // Untested
+		adfNode.Text = string(ntype.Value)
+		adfNode.Marks = r.textMarks()
+		r.context.PushText(adfNode)
 
 	case *ast.CodeSpan:
 		adfNode.Text = string(n.Text(source))
-		adfNode.Marks = []MarkStruct{{Type: MarkCode}}
-		r.context.PushContent(adfNode)
-		return ast.WalkSkipChildren
+		adfNode.Marks = r.codeMarks()
+		if len(adfNode.Marks) < len(r.textMarks())+1 {
+			r.unsupported(n, "formatted code", "plain code")
+		}
+		r.context.PushText(adfNode)
+		return ast.WalkSkipChildren, nil
 
 	case *extAst.Strikethrough:
-		adfNode.Text = string(n.Text(source))
-		adfNode.Marks = []MarkStruct{{Type: MarkStrike}}
-		r.context.PushContent(adfNode)
-		return ast.WalkSkipChildren
+		r.marks = append(r.marks, MarkStruct{Type: MarkStrike})
 
 	case *ast.Emphasis:
-		adfNode.Text = string(n.Text(source))
 		if ntype.Level == 1 {
-			adfNode.Marks = []MarkStruct{{Type: MarkEm}}
-		} else if ntype.Level >= 2 {
-			adfNode.Marks = []MarkStruct{{Type: MarkStrong}}
+			r.marks = append(r.marks, MarkStruct{Type: MarkEm})
+		} else {
+			r.marks = append(r.marks, MarkStruct{Type: MarkStrong})
 		}
-		r.context.PushContent(adfNode)
-		return ast.WalkSkipChildren
+
+	case *Underline:
+		r.marks = append(r.marks, MarkStruct{Type: MarkUnderline})
+
+	case *Subsup:
+		r.marks = append(r.marks, MarkStruct{
+			Type:       MarkSubsup,
+			Attributes: &MarkAttributes{Type: ntype.SubsupType},
+		})
+
+	case *TextColor:
+		r.marks = append(r.marks, MarkStruct{
+			Type:       MarkTextcolor,
+			Attributes: &MarkAttributes{Color: ntype.Color},
+		})
+
+	case *Status:
+		r.context.PushContent(r.status(ntype))
+
+	case *Date:
+		r.context.PushContent(date(ntype))
 
 	case *ast.Link:
-		adfNode.Text = string(n.Text(source))
-		adfNode.Marks = []MarkStruct{{
+		r.marks = append(r.marks, MarkStruct{
 			Type: MarkLink,
 			Attributes: &MarkAttributes{
 				Href:  string(ntype.Destination),
 				Title: string(ntype.Title),
 			},
-		}}
-		r.context.PushContent(adfNode)
-		return ast.WalkSkipChildren
+		})
 
-	case *ast.Image:
-		// if entering {
-		// 	children := r.renderChildren(source, n)
-		// 	r.image(tnode.Destination, tnode.Title, children)
-		// }
-		// return ast.WalkSkipChildren
+	case *ADFPlaceholder:
+		if ntype.Err != nil {
+			return ast.WalkStop, placeholderError(source, ntype.Offset, ntype.Err)
+		}
+		r.context.PushContent(opaqueNode(ntype.Raw))
 
-	case *ast.FencedCodeBlock:
-		adfNode.Attributes = &Attributes{
-			Language: string(ntype.Language(source)),
+	case *ast.AutoLink:
+		link := string(ntype.URL(source))
+		if ntype.AutoLinkType == ast.AutoLinkEmail && !strings.HasPrefix(strings.ToLower(link), "mailto:") {
+			link = "mailto:" + link
 		}
+		adfNode.Text = string(ntype.Label(source))
+		adfNode.Marks = r.textMarks()
+		if hasMark(r.marks, MarkLink) {
+			// Already the text of a link, ADF does not nest links
+			r.context.PushText(adfNode)
+			break
+		}
+		if r.smartLinks != nil && r.smartLinks.matchesURL(link) {
+			r.context.PushContent(inlineCard(link))
+			break
+		}
+		adfNode.Marks = append(adfNode.Marks, MarkStruct{
+			Type:       MarkLink,
+			Attributes: &MarkAttributes{Href: link},
+		})
+		r.context.PushText(adfNode)
+
+	case *emojiAst.Emoji:
+		r.context.PushContent(emojiNode(ntype))
+
+	case *Mention:
+		mention, err := r.mention(source, ntype)
+		if err != nil {
+			return ast.WalkStop, err
+		}
+		if mention.Type == NodeTypeText {
+			mention.Marks = r.textMarks()
+			r.context.PushText(mention)
+		} else {
+			r.context.PushContent(mention)
+		}
+
+	case *ast.Image:
+		var media *Node
+		if !hasMark(r.marks, MarkLink) {
+			media = r.image(source, ntype)
+		}
+		// Images are inline in markdown but media is a block, so the paragraph is split around it
+		if media == nil || !r.context.BreakParagraph(media) {
+			r.unsupported(n, "image "+string(ntype.Destination), "text")
+			r.context.PushText(r.imageText(source, ntype))
+		}
+		return ast.WalkSkipChildren, nil
+
+	case *ast.FencedCodeBlock,
+		*ast.CodeBlock:
 		var content string
 		lines := ntype.Lines()
 		for i := 0; i < lines.Len(); i++ {
 			segment := lines.At(i)
 			content += string(segment.Value(source))
 		}
+
+		if isPlaceholderBlock(source, n) {
+			raw, err := opaqueJSON([]byte(content))
+			if err != nil {
+				return ast.WalkStop, placeholderError(source, n.(*ast.FencedCodeBlock).Info.Segment.Start, err)
+			}
+			// Placeholders hold nodes exactly as Jira sent them, so they skip the nesting rules of the stack
+			r.context.PeekBlockNode().AddContent(opaqueNode(raw))
+			return ast.WalkSkipChildren, nil
+		}
+
+		if fenced, ok := n.(*ast.FencedCodeBlock); ok && len(fenced.Language(source)) > 0 {
+			adfNode.Attributes = &Attributes{
+				Language: string(fenced.Language(source)),
+			}
+		}
 		adfNode.AddContent(&Node{
 			Type: NodeTypeText,
 			Text: content,
 		})
 		r.context.PushBlockNode(adfNode)
-		return ast.WalkSkipChildren
+		return ast.WalkSkipChildren, nil
 
 	case *ast.HTMLBlock:
-		// if entering {
-		// 	r.blockHtml(tnode, source)
-		// }
+		var content string
+		lines := ntype.Lines()
+		for i := 0; i < lines.Len(); i++ {
+			segment := lines.At(i)
+			content += string(segment.Value(source))
+		}
+		r.unsupported(n, "HTML", "text")
+		adfNode.Type = NodeTypeParagraph
+		if content = strings.TrimRight(content, "\n"); content != "" {
+			adfNode.AddContent(&Node{
+				Type: NodeTypeText,
+				Text: content,
+			})
+		}
+		r.context.PushBlockNode(adfNode)
+		return ast.WalkSkipChildren, nil
+
 	case *ast.RawHTML:
-		// if entering {
-		// 	r.rawHtml(tnode, source)
-		// }
-		// return ast.WalkSkipChildren
+		var content string
+		for i := 0; i < ntype.Segments.Len(); i++ {
+			segment := ntype.Segments.At(i)
+			content += string(segment.Value(source))
+		}
+		if lineBreakTag.MatchString(content) {
+			r.context.PushContent(&Node{Type: NodeTypeHardBreak})
+			break
+		}
+		r.unsupported(n, "HTML", "text")
+		adfNode.Text = content
+		adfNode.Marks = r.textMarks()
+		r.context.PushText(adfNode)
 	case *extAst.Table:
-		// r.table(tnode, entering)
-	case *extAst.TableHeader:
-		// if entering {
-		// 	r.tableIsHeader = true
-		// }
-	case *extAst.TableRow:
-		// if entering {
-		// 	r.tableIsHeader = false
-		// }
+		adfNode.Attributes = &Attributes{
+			Layout: LayoutDefault,
+		}
+		r.context.PushBlockNode(adfNode)
+
+	case *extAst.TableHeader,
+		*extAst.TableRow:
+		r.context.PushBlockNode(adfNode)
+
 	case *extAst.TableCell:
-		// if entering {
-		// 	children := r.renderChildren(source, n)
-		// 	if r.tableIsHeader {
-		// 		r.tableHeaderCell(children, tnode.Alignment)
-		// 	} else {
-		// 		r.tableCell(children)
-		// 	}
-		// }
-		// return ast.WalkSkipChildren
+		r.context.PushBlockNode(adfNode)
+
+		// ADF cells hold block content, so the inline children of a cell are wrapped in a paragraph
+		paragraph := &Node{Type: NodeTypeParagraph}
+		if mark := alignmentMark(ntype.Alignment); mark != nil {
+			paragraph.Marks = []MarkStruct{*mark}
+		}
+		r.context.PushBlockNode(paragraph)
+
 	default:
-		panic("unknown type" + n.Kind().String())
+		// Keep the content of markdown extensions this renderer does not know about
+		if n.Type() == ast.TypeBlock && n.Lines().Len() > 0 {
+			r.unsupported(n, n.Kind().String(), "a paragraph")
+			adfNode.Type = NodeTypeParagraph
+			r.context.PushBlockNode(adfNode)
+		} else if n.Type() == ast.TypeBlock {
+			r.unsupported(n, n.Kind().String(), "its content")
+			r.context.DropBlockNode(adfNode)
+		} else {
+			r.unsupported(n, n.Kind().String(), "text")
+		}
+	}
+
+	return ast.WalkContinue, nil
+}
+
+// textMarks returns the marks for a text node at the current position. Each mark type is only applied once,
+// so **bold **and bold** text** does not produce two strong marks.
+func (r *renderState) textMarks() []MarkStruct {
+	var marks []MarkStruct
+	for _, mark := range r.marks {
+		if !hasMark(marks, mark.Type) {
+			marks = append(marks, mark)
+		}
 	}
+	return marks
+}
+
+// codeMarks returns the marks for a code span at the current position.
+// ADF only allows code to be combined with links, any other formatting around the code span is dropped.
+func (r *renderState) codeMarks() []MarkStruct {
+	var marks []MarkStruct
+	for _, mark := range r.textMarks() {
+		if mark.Type == MarkLink {
+			marks = append(marks, mark)
+		}
+	}
+	return append(marks, MarkStruct{Type: MarkCode})
+}
+
+// unescapedText resolves backslash escapes and character references in a single pass, the same way goldmark's
+// HTML renderer does, so an escaped \&copy; stays literal text instead of becoming a reference
+func unescapedText(value []byte) string {
+	var out strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '\\' && i+1 < len(value) && util.IsPunct(value[i+1]):
+			i++
+			out.WriteByte(value[i])
+		case c == '&':
+			end := bytes.IndexByte(value[i:], ';')
+			if end < 0 {
+				out.WriteByte(c)
+				break
+			}
+			reference := value[i : i+end+1]
+			resolved := util.ResolveNumericReferences(reference)
+			if bytes.Equal(resolved, reference) {
+				resolved = util.ResolveEntityNames(reference)
+			}
+			if bytes.Equal(resolved, reference) {
+				out.WriteByte(c)
+				break
+			}
+			out.Write(resolved)
+			i += end
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.String()
+}
 
-	return ast.WalkContinue
+// alignmentMark converts a table column alignment into an ADF paragraph alignment mark.
+// Left aligned and unaligned columns use the default ADF alignment, so no mark is needed.
+func alignmentMark(alignment extAst.Alignment) *MarkStruct {
+	var align string
+	switch alignment {
+	case extAst.AlignCenter:
+		align = "center"
+	case extAst.AlignRight:
+		align = "end"
+	default:
+		return nil
+	}
+	return &MarkStruct{
+		Type:       MarkAlignment,
+		Attributes: &MarkAttributes{Align: align},
+	}
 }
 
-// Render implements goldmark.Renderer interface.
+// Render implements renderer.Renderer, writing the document as JSON
 func (r *ADFRenderer) Render(w io.Writer, source []byte, n ast.Node) error {
+	document, err := r.convert(source, n)
+	if err != nil {
+		return err
+	}
+
+	var b []byte
+	if r.compact {
+		b, err = json.Marshal(document)
+	} else {
+		b, err = json.MarshalIndent(document, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func (r *ADFRenderer) convert(source []byte, n ast.Node) (*Node, error) {
+	state := newRenderState(r, source)
 	for current := n.FirstChild(); current != nil; current = current.NextSibling() {
 		err := ast.Walk(current, func(current ast.Node, entering bool) (ast.WalkStatus, error) {
-			return r.walkNode(source, current, entering), nil
+			return state.walkNode(source, current, entering)
 		})
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	b, err := json.MarshalIndent(r.document, "", "  ")
-	if err != nil {
-		return err
+	if len(state.errors) > 0 {
+		return nil, &UnsupportedError{Unsupported: state.errors}
 	}
-	w.Write(b)
 
-	return nil
+	if r.smartLinks != nil {
+		if pattern := r.smartLinks.issueKeyPattern(); pattern != nil {
+			r.smartLinks.linkIssueKeys(state.document, pattern)
+		}
+	}
+
+	if r.original != nil {
+		keepLocalIDs(r.original, state.document)
+	}
+
+	if len(state.uploads) > 0 {
+		// Nothing is attached to the issue for a document Jira would reject
+		if err := Validate(state.document); err != nil {
+			return nil, err
+		}
+		if err := state.upload(); err != nil {
+			return nil, err
+		}
+	}
+	return state.document, nil
 }
 
-func (*ADFRenderer) AddOptions(...renderer.Option) {
-	//panic("No options for ADF renderer")
+// AddOptions implements renderer.Renderer, applying the options of this package and ignoring any others
+func (r *ADFRenderer) AddOptions(opts ...renderer.Option) {
+	for _, opt := range opts {
+		if option, ok := opt.(Option); ok {
+			option(r)
+		}
+	}
 }
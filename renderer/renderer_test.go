@@ -0,0 +1,69 @@
+package renderer
+
+import (
+	"sync"
+	"testing"
+)
+
+// lockedUploader is a recordingUploader that can be shared between goroutines
+type lockedUploader struct {
+	mu sync.Mutex
+	recordingUploader
+}
+
+func (u *lockedUploader) UploadMedia(path string) (string, string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.recordingUploader.UploadMedia(path)
+}
+
+// TestSharedRenderer renders documents on one renderer from several goroutines, run it with -race
+func TestSharedRenderer(t *testing.T) {
+	chart := writeImage(t, "chart.png")
+	markdown := []byte("Thanks @jane.doe, see OPS-12\n\n![chart](" + chart + ")\n\n" +
+		"- [ ] Ship it {status:green|READY}\n\n| A | B |\n| - | - |\n| **1** | 2 |\n\n" + unsupportedMarkdown)
+
+	uploader := &lockedUploader{}
+	var warnings sync.Map
+	r := NewRenderer(
+		WithMentionResolver(team),
+		WithMediaUploader(uploader),
+		WithSmartLinks(SmartLinks{BaseURL: "https://example.atlassian.net", ProjectKeys: []string{"OPS"}}),
+		WithWarnings(func(u Unsupported) { warnings.Store(u.Construct, true) }),
+	)
+
+	const goroutines, documents = 8, 10
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < documents; j++ {
+				doc, err := r.ToADF(markdown)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if err := Validate(doc); err != nil {
+					t.Errorf("Validate: %v", err)
+				}
+				for nodeType, want := range map[NodeType]int{
+					NodeTypeMention: 1, NodeTypeInlineCard: 1, NodeTypeMedia: 1, NodeTypeTaskItem: 1,
+					NodeTypeStatus: 1, NodeTypeTableCell: 2,
+				} {
+					if got := countNodes(t, doc, nodeType); got != want {
+						t.Errorf("%d %s nodes, want %d", got, nodeType, want)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(uploader.paths) != goroutines*documents {
+		t.Errorf("Uploaded %d images, want one for each of the %d documents", len(uploader.paths), goroutines*documents)
+	}
+	if _, ok := warnings.Load("HTML"); !ok {
+		t.Error("No warning about the HTML")
+	}
+}
@@ -0,0 +1,103 @@
+package renderer
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// SmartLinks configures which issue keys and URLs are rendered as inline cards, the smart links Jira shows
+// with the title and status of what they point to.
+type SmartLinks struct {
+	BaseURL     string   // Jira site bare issue keys link to, e.g. https://example.atlassian.net
+	ProjectKeys []string // Bare issue keys of these projects become smart links, e.g. OPS for OPS-1234
+	Hosts       []string // Bare URLs on these hosts become smart links, the host of BaseURL is always included
+}
+
+// WithSmartLinks renders bare issue keys and Jira or Confluence URLs as inline cards.
+func WithSmartLinks(links SmartLinks) Option {
+	return func(r *ADFRenderer) {
+		r.smartLinks = &links
+	}
+}
+
+func (s *SmartLinks) issueKeyPattern() *regexp.Regexp {
+	if s.BaseURL == "" {
+		return nil
+	}
+	keys := make([]string, 0, len(s.ProjectKeys))
+	for _, key := range s.ProjectKeys {
+		// An empty key would match any "-123"
+		if key = strings.ToUpper(strings.TrimSpace(key)); key != "" {
+			keys = append(keys, regexp.QuoteMeta(key))
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return regexp.MustCompile(`\b(?:` + strings.Join(keys, "|") + `)-[0-9]+\b`)
+}
+
+// matchesURL reports whether a bare URL points at one of the smart link hosts
+func (s *SmartLinks) matchesURL(link string) bool {
+	parsed, err := url.Parse(link)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return false
+	}
+
+	hosts := s.Hosts
+	if base, err := url.Parse(s.BaseURL); err == nil && base.Host != "" {
+		hosts = append([]string{base.Host}, hosts...)
+	}
+	for _, host := range hosts {
+		if strings.EqualFold(parsed.Host, strings.TrimSpace(host)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *SmartLinks) issueURL(key string) string {
+	return strings.TrimSuffix(s.BaseURL, "/") + "/browse/" + key
+}
+
+func inlineCard(link string) *Node {
+	return &Node{
+		Type:       NodeTypeInlineCard,
+		Attributes: &Attributes{URL: link},
+	}
+}
+
+// linkIssueKeys replaces bare issue keys in the text of a finished document with inline cards.
+// It runs on the whole document because goldmark may split a key over several text nodes.
+// Code blocks only hold text, and verbatim nodes are kept exactly as Jira sent them.
+func (s *SmartLinks) linkIssueKeys(n *Node, pattern *regexp.Regexp) {
+	if n.verbatim || n.Type == NodeTypeCodeBlock {
+		return
+	}
+
+	content := make([]*Node, 0, len(n.Content))
+	for _, child := range n.Content {
+		s.linkIssueKeys(child, pattern)
+		if child.Type != NodeTypeText || child.verbatim || hasMark(child.Marks, MarkLink) || hasMark(child.Marks, MarkCode) {
+			content = append(content, child)
+			continue
+		}
+
+		// Inline cards cannot carry marks, so the text around a key keeps its marks but the key drops them
+		last := 0
+		for _, match := range pattern.FindAllStringIndex(child.Text, -1) {
+			if match[0] > last {
+				content = append(content, &Node{Type: NodeTypeText, Text: child.Text[last:match[0]], Marks: child.Marks})
+			}
+			content = append(content, inlineCard(s.issueURL(child.Text[match[0]:match[1]])))
+			last = match[1]
+		}
+		if last == 0 {
+			content = append(content, child)
+		} else if last < len(child.Text) {
+			content = append(content, &Node{Type: NodeTypeText, Text: child.Text[last:], Marks: child.Marks})
+		}
+	}
+	n.Content = content
+}
@@ -0,0 +1,73 @@
+package renderer
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// countNodes counts the nodes of a type in a document, including those in verbatim placeholders
+func countNodes(t *testing.T, doc *Node, nodeType NodeType) int {
+	t.Helper()
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Count(string(raw), `"type":"`+string(nodeType)+`"`)
+}
+
+func TestIssueKeySmartLinks(t *testing.T) {
+	tests := []struct {
+		name        string
+		markdown    string
+		projectKeys []string
+		cards       int
+	}{
+		{name: "bare key", markdown: "see OPS-12", projectKeys: []string{"OPS"}, cards: 1},
+		{name: "other project", markdown: "see DEV-12", projectKeys: []string{"OPS"}, cards: 0},
+		{name: "fenced code block", markdown: "```\nsee OPS-12\n```", projectKeys: []string{"OPS"}, cards: 0},
+		{name: "indented code block", markdown: "text\n\n    see OPS-12", projectKeys: []string{"OPS"}, cards: 0},
+		{name: "code span", markdown: "see `OPS-12`", projectKeys: []string{"OPS"}, cards: 0},
+		{
+			name:        "placeholder",
+			markdown:    "```adf\n{\"type\":\"paragraph\",\"content\":[{\"type\":\"text\",\"text\":\"OPS-12\"}]}\n```",
+			projectKeys: []string{"OPS"},
+			cards:       0,
+		},
+		{name: "empty key next to a key", markdown: "build-12 and OPS-3", projectKeys: []string{"OPS", ""}, cards: 1},
+		{name: "only empty keys", markdown: "build-12", projectKeys: []string{"", " "}, cards: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			doc, err := ToADF([]byte(test.markdown), WithSmartLinks(SmartLinks{
+				BaseURL:     "https://example.atlassian.net",
+				ProjectKeys: test.projectKeys,
+			}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cards := countNodes(t, doc, NodeTypeInlineCard); cards != test.cards {
+				raw, _ := json.Marshal(doc)
+				t.Errorf("%q has %d inline cards, want %d:\n%s", test.markdown, cards, test.cards, raw)
+			}
+			if err := Validate(doc); err != nil {
+				t.Errorf("Validate: %v", err)
+			}
+		})
+	}
+}
+
+func TestIssueKeyPatternSkipsEmptyKeys(t *testing.T) {
+	links := SmartLinks{BaseURL: "https://example.atlassian.net", ProjectKeys: []string{"ops", "", "  "}}
+	pattern := links.issueKeyPattern()
+	if pattern == nil {
+		t.Fatal("No pattern for project OPS")
+	}
+	if strings.Contains(pattern.String(), "|)") || strings.Contains(pattern.String(), "(?:|") {
+		t.Errorf("Pattern %s has an empty alternative", pattern)
+	}
+	if pattern.MatchString("-12") {
+		t.Errorf("Pattern %s matches a number without a project key", pattern)
+	}
+}
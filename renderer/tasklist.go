@@ -0,0 +1,155 @@
+package renderer
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	extAst "github.com/yuin/goldmark/extension/ast"
+)
+
+// Enum values for State in Attributes struct
+const (
+	TaskStateTodo = "TODO"
+	TaskStateDone = "DONE"
+)
+
+// isTaskList reports whether every item of a list starts with a checkbox
+func isTaskList(n ast.Node) bool {
+	list, ok := n.(*ast.List)
+	if !ok || !list.HasChildren() {
+		return false
+	}
+	for item := list.FirstChild(); item != nil; item = item.NextSibling() {
+		if taskCheckBox(item) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func taskCheckBox(item ast.Node) *extAst.TaskCheckBox {
+	if item.FirstChild() == nil {
+		return nil
+	}
+	checkBox, _ := item.FirstChild().FirstChild().(*extAst.TaskCheckBox)
+	return checkBox
+}
+
+func taskState(item ast.Node) string {
+	if checkBox := taskCheckBox(item); checkBox != nil && checkBox.IsChecked {
+		return TaskStateDone
+	}
+	return TaskStateTodo
+}
+
+// WithOriginal names the document a markdown edit started from, so the edited document keeps its local IDs and Jira
+// does not take every task for a new one. Task items keep the ID of the original item with the same text, task lists
+// the ID of the original task list in the same position.
+func WithOriginal(doc *Node) Option {
+	return func(r *ADFRenderer) {
+		r.original = doc
+	}
+}
+
+// localID identifies a new task list, task item or status
+func (r *renderState) localID() string {
+	if r.newLocalID != nil {
+		return r.newLocalID()
+	}
+	return randomID()
+}
+
+// randomID generates the random identifier ADF requires on task lists and task items
+func randomID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40 // Version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// taskList writes a task list as GFM checkboxes, nested task lists are indented under the item before them
+func (w *markdownWriter) taskList(n *Node) (string, error) {
+	var items []string
+	for _, child := range n.Content {
+		switch child.Type {
+		case NodeTypeTaskItem:
+			content, err := w.inline(child.Content)
+			if err != nil {
+				return "", err
+			}
+			checkBox := "- [ ] "
+			if child.Attributes != nil && child.Attributes.State == TaskStateDone {
+				checkBox = "- [x] "
+			}
+			items = append(items, checkBox+strings.TrimPrefix(prefixLines(content, "  ", ""), "  "))
+		case NodeTypeTaskList:
+			nested, err := w.taskList(child)
+			if err != nil {
+				return "", err
+			}
+			if len(items) == 0 {
+				// A nested list needs an item to hang from
+				items = append(items, "- [ ]")
+			}
+			items = append(items, prefixLines(nested, "  ", ""))
+		default:
+			return blockPlaceholder(n)
+		}
+	}
+	return strings.Join(items, "\n"), nil
+}
+
+// keepLocalIDs gives the task lists and task items of the document the local IDs of their counterparts in the original
+func keepLocalIDs(original, document *Node) {
+	var lists []string
+	items := map[string][]string{}
+	walkNodes(original, func(n *Node) {
+		if n.Attributes == nil || n.Attributes.LocalID == "" {
+			return
+		}
+		switch n.Type {
+		case NodeTypeTaskList:
+			lists = append(lists, n.Attributes.LocalID)
+		case NodeTypeTaskItem:
+			key := plainText(n)
+			items[key] = append(items[key], n.Attributes.LocalID)
+		}
+	})
+
+	walkNodes(document, func(n *Node) {
+		switch n.Type {
+		case NodeTypeTaskList:
+			if len(lists) > 0 {
+				n.Attributes.LocalID, lists = lists[0], lists[1:]
+			}
+		case NodeTypeTaskItem:
+			key := plainText(n)
+			if ids := items[key]; len(ids) > 0 {
+				n.Attributes.LocalID, items[key] = ids[0], ids[1:]
+			}
+		}
+	})
+}
+
+// walkNodes calls visit for the node and everything below it, parents first
+func walkNodes(n *Node, visit func(*Node)) {
+	visit(n)
+	for _, child := range n.Content {
+		walkNodes(child, visit)
+	}
+}
+
+// plainText is the text a reader sees in the node, marks aside
+func plainText(n *Node) string {
+	var b strings.Builder
+	walkNodes(n, func(n *Node) {
+		b.WriteString(n.Text)
+		if n.Type != NodeTypeText && n.Attributes != nil {
+			b.WriteString(n.Attributes.Text + n.Attributes.URL)
+		}
+	})
+	return b.String()
+}
@@ -0,0 +1,80 @@
+package renderer
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestTaskIDsSurviveEdit(t *testing.T) {
+	original := new(Node)
+	err := json.Unmarshal([]byte(`{"type":"doc","version":1,"content":[
+		{"type":"taskList","attrs":{"localId":"list-1"},"content":[
+			{"type":"taskItem","attrs":{"localId":"item-1","state":"DONE"},"content":[{"type":"text","text":"Write tests"}]},
+			{"type":"taskItem","attrs":{"localId":"item-2","state":"TODO"},"content":[{"type":"text","text":"Ship it"}]}
+		]}]}`), original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	markdown, err := ToMarkdown(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tick the second task, and add one before the others
+	edited := strings.Replace(markdown, "- [ ] Ship it", "- [x] Ship it", 1)
+	edited = "- [ ] Plan the release\n" + edited
+	doc, err := ToADF([]byte(edited), WithOriginal(original))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(doc.Content) != 1 || doc.Content[0].Type != NodeTypeTaskList || len(doc.Content[0].Content) != 3 {
+		t.Fatalf("Want a task list of 3 items from %q, got %+v", edited, doc.Content)
+	}
+	list := doc.Content[0]
+	if list.Attributes.LocalID != "list-1" {
+		t.Errorf("Task list has local ID %q, want list-1", list.Attributes.LocalID)
+	}
+	added, kept := list.Content[0].Attributes, []*Attributes{list.Content[1].Attributes, list.Content[2].Attributes}
+	if added.LocalID == "" || strings.HasPrefix(added.LocalID, "item-") {
+		t.Errorf("New task has local ID %q, want a new one", added.LocalID)
+	}
+	if kept[0].LocalID != "item-1" || kept[1].LocalID != "item-2" {
+		t.Errorf("Edited tasks have local IDs %q and %q, want item-1 and item-2", kept[0].LocalID, kept[1].LocalID)
+	}
+	if kept[1].State != TaskStateDone {
+		t.Errorf("Ticked task is %s, want %s", kept[1].State, TaskStateDone)
+	}
+	if err := Validate(doc); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+}
+
+func TestTaskIDsAreNotReused(t *testing.T) {
+	original, err := ToADF([]byte("- [ ] Same\n- [ ] Same"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Three items with the text of two, the third cannot share an ID with either
+	doc, err := ToADF([]byte("- [ ] Same\n- [ ] Same\n- [ ] Same"), WithOriginal(original))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[string]bool{}
+	walkNodes(doc, func(n *Node) {
+		if n.Type != NodeTypeTaskItem {
+			return
+		}
+		if id := n.Attributes.LocalID; seen[id] {
+			t.Errorf("Local ID %s is used twice", id)
+		}
+		seen[n.Attributes.LocalID] = true
+	})
+	for _, item := range original.Content[0].Content {
+		if !seen[item.Attributes.LocalID] {
+			t.Errorf("Local ID %s of the original is not kept", item.Attributes.LocalID)
+		}
+	}
+}
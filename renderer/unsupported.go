@@ -0,0 +1,94 @@
+package renderer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+)
+
+// Unsupported describes markdown that ADF cannot represent and what it was turned into instead
+type Unsupported struct {
+	Construct string // e.g. "heading inside of blockquote"
+	Fallback  string // e.g. "paragraph"
+	Line      int    // 1-based, 0 when the position is unknown
+	Column    int    // 1-based, 0 when the position is unknown
+}
+
+func (u Unsupported) String() string {
+	description := u.Construct + " is not supported by Jira (falls back to " + u.Fallback + ")"
+	if u.Line == 0 {
+		return description
+	}
+	return fmt.Sprintf("line %d, column %d: %s", u.Line, u.Column, description)
+}
+
+// UnsupportedError is returned in strict mode, listing every construct that would have been degraded
+type UnsupportedError struct {
+	Unsupported []Unsupported
+}
+
+func (e *UnsupportedError) Error() string {
+	lines := make([]string, 0, len(e.Unsupported))
+	for _, u := range e.Unsupported {
+		lines = append(lines, u.String())
+	}
+	return "markdown cannot be converted to ADF:\n\t" + strings.Join(lines, "\n\t")
+}
+
+// WithStrict fails rendering with an *UnsupportedError instead of degrading markdown that ADF cannot represent.
+func WithStrict() Option {
+	return func(r *ADFRenderer) {
+		r.strict = true
+	}
+}
+
+// WithWarnings reports each construct that is degraded to fit ADF, such as a table inside of a blockquote.
+// Warnings are not reported in strict mode, they are returned as an error instead.
+func WithWarnings(report func(Unsupported)) Option {
+	return func(r *ADFRenderer) {
+		r.warnings = report
+	}
+}
+
+// unsupported records a degraded construct as an error in strict mode and as a warning otherwise
+func (r *renderState) unsupported(n ast.Node, construct, fallback string) {
+	u := Unsupported{Construct: construct, Fallback: fallback}
+	if start := offset(n); start >= 0 {
+		u.Line, u.Column = position(r.source, start)
+	}
+
+	if r.strict {
+		r.errors = append(r.errors, u)
+	} else if r.warnings != nil {
+		r.warnings(u)
+	}
+}
+
+// offset finds where a node starts in the source, nodes without a position of their own use their first child's
+func offset(n ast.Node) int {
+	if n == nil {
+		return -1
+	}
+	switch node := n.(type) {
+	case *ast.Text:
+		return node.Segment.Start
+	case *ast.RawHTML:
+		if node.Segments.Len() > 0 {
+			return node.Segments.At(0).Start
+		}
+	case *Mention:
+		return node.Offset
+	case *ADFPlaceholder:
+		return node.Offset
+	}
+	if n.Type() == ast.TypeBlock && n.Lines().Len() > 0 {
+		return n.Lines().At(0).Start
+	}
+	for child := n.FirstChild(); child != nil; child = child.NextSibling() {
+		if start := offset(child); start >= 0 {
+			return start
+		}
+	}
+	return -1
+}
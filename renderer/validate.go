@@ -0,0 +1,274 @@
+package renderer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidationError is a place where a document breaks the ADF content model
+type ValidationError struct {
+	Path    string // Where the problem is, e.g. doc.content[2].content[0].marks[1]
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+// ValidationErrors lists every problem found in a document
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	lines := make([]string, 0, len(e))
+	for _, err := range e {
+		lines = append(lines, err.Error())
+	}
+	return "invalid ADF document:\n\t" + strings.Join(lines, "\n\t")
+}
+
+// nodeSpec is the part of the ADF schema a node is checked against
+type nodeSpec struct {
+	content    []NodeType         // Children the node may hold
+	first      []NodeType         // Children the node may start with, when stricter than content
+	minContent int                // Least number of children
+	maxContent int                // Most number of children, 0 for no limit
+	marks      []Mark             // Marks the node may carry
+	attrs      func(*Node) string // Checks the attributes, returning what is wrong with them
+}
+
+var (
+	topLevelBlocks = []NodeType{NodeTypeParagraph, NodeTypeHeading, NodeTypeBulletList, NodeTypeOrderedList,
+		NodeTypeBlockquote, NodeTypeCodeBlock, NodeTypeRule, NodeTypePanel, NodeTypeTable, NodeTypeMediaSingle,
+		NodeTypeMediaGroup, NodeTypeTaskList, NodeTypeExpand}
+	inlineNodes = []NodeType{NodeTypeText, NodeTypeHardBreak, NodeTypeMention, NodeTypeEmoji, NodeTypeInlineCard,
+		NodeTypeStatus, NodeTypeDate}
+	textMarks = []Mark{MarkCode, MarkEm, MarkLink, MarkStrike, MarkStrong, MarkSubsup, MarkTextcolor,
+		MarkUnderline}
+	blockMarks = []Mark{MarkAlignment}
+)
+
+// nodeSpecs describes the nodes this package produces, other nodes are only produced by Jira and are not checked
+var nodeSpecs = map[NodeType]nodeSpec{
+	NodeTypeDoc: {content: topLevelBlocks, attrs: func(n *Node) string {
+		if n.Version != 1 {
+			return "version must be 1"
+		}
+		return ""
+	}},
+	NodeTypeParagraph: {content: inlineNodes, marks: blockMarks},
+	NodeTypeHeading: {content: inlineNodes, marks: blockMarks, attrs: func(n *Node) string {
+		if n.Attributes == nil || n.Attributes.Level < 1 || n.Attributes.Level > 6 {
+			return "level must be between 1 and 6"
+		}
+		return ""
+	}},
+	NodeTypeBulletList:  {content: []NodeType{NodeTypeListItem}, minContent: 1},
+	NodeTypeOrderedList: {content: []NodeType{NodeTypeListItem}, minContent: 1},
+	NodeTypeListItem: {
+		content:    allowedContent[NodeTypeListItem],
+		first:      []NodeType{NodeTypeParagraph, NodeTypeMediaSingle, NodeTypeCodeBlock},
+		minContent: 1,
+	},
+	NodeTypeBlockquote: {content: allowedContent[NodeTypeBlockquote], minContent: 1},
+	NodeTypeCodeBlock:  {content: []NodeType{NodeTypeText}},
+	NodeTypeRule:       {},
+	NodeTypePanel: {content: allowedContent[NodeTypePanel], minContent: 1, attrs: func(n *Node) string {
+		if n.Attributes == nil || n.Attributes.PanelType == "" {
+			return "panelType is required"
+		}
+		return ""
+	}},
+	NodeTypeExpand:       {content: allowedContent[NodeTypeExpand], minContent: 1},
+	NodeTypeNestedExpand: {content: allowedContent[NodeTypeNestedExpand], minContent: 1},
+	NodeTypeTable:        {content: []NodeType{NodeTypeTableRow}, minContent: 1},
+	NodeTypeTableRow:     {content: []NodeType{NodeTypeTableHeader, NodeTypeTableCell}, minContent: 1},
+	NodeTypeTableHeader:  {content: allowedContent[NodeTypeTableHeader], minContent: 1},
+	NodeTypeTableCell:    {content: allowedContent[NodeTypeTableCell], minContent: 1},
+	NodeTypeMediaSingle:  {content: []NodeType{NodeTypeMedia}, minContent: 1, maxContent: 1},
+	NodeTypeMediaGroup:   {content: []NodeType{NodeTypeMedia}, minContent: 1},
+	NodeTypeMedia: {attrs: func(n *Node) string {
+		switch {
+		case n.Attributes == nil:
+			return "type is required"
+		case n.Attributes.Type == MediaTypeFile && n.Attributes.ID == "":
+			return "id is required for file media"
+		case n.Attributes.Type == MediaTypeFile && n.Attributes.Collection == nil:
+			return "collection is required for file media"
+		case n.Attributes.Type == MediaTypeExternal && n.Attributes.URL == "":
+			return "url is required for external media"
+		case n.Attributes.Type != MediaTypeFile && n.Attributes.Type != MediaTypeExternal && n.Attributes.Type != "link":
+			return fmt.Sprintf("unknown media type %q", n.Attributes.Type)
+		}
+		return ""
+	}},
+	NodeTypeTaskList: {
+		content:    allowedContent[NodeTypeTaskList],
+		first:      []NodeType{NodeTypeTaskItem},
+		minContent: 1,
+		attrs:      requireLocalID,
+	},
+	NodeTypeTaskItem: {content: inlineNodes, attrs: func(n *Node) string {
+		if message := requireLocalID(n); message != "" {
+			return message
+		}
+		if n.Attributes.State != TaskStateTodo && n.Attributes.State != TaskStateDone {
+			return "state must be TODO or DONE"
+		}
+		return ""
+	}},
+	NodeTypeText: {marks: textMarks, attrs: func(n *Node) string {
+		if n.Text == "" {
+			return "text must not be empty"
+		}
+		return ""
+	}},
+	NodeTypeHardBreak: {},
+	NodeTypeMention: {attrs: func(n *Node) string {
+		if n.Attributes == nil || n.Attributes.ID == "" {
+			return "id is required"
+		}
+		return ""
+	}},
+	NodeTypeEmoji: {attrs: func(n *Node) string {
+		if n.Attributes == nil || n.Attributes.ShortName == "" {
+			return "shortName is required"
+		}
+		return ""
+	}},
+	NodeTypeInlineCard: {attrs: func(n *Node) string {
+		if n.Attributes == nil || n.Attributes.URL == "" {
+			return "url is required"
+		}
+		return ""
+	}},
+	NodeTypeStatus: {attrs: func(n *Node) string {
+		switch {
+		case n.Attributes == nil || n.Attributes.Text == "":
+			return "text is required"
+		case n.Attributes.Color == "":
+			return "color is required"
+		}
+		return ""
+	}},
+	NodeTypeDate: {attrs: func(n *Node) string {
+		if n.Attributes == nil || n.Attributes.Timestamp == "" {
+			return "timestamp is required"
+		}
+		return ""
+	}},
+}
+
+func requireLocalID(n *Node) string {
+	if n.Attributes == nil || n.Attributes.LocalID == "" {
+		return "localId is required"
+	}
+	return ""
+}
+
+// Validate checks a document against the ADF content model before it is sent to Jira, which only answers
+// invalid documents with a bare 400. It returns ValidationErrors listing every problem found.
+// Nodes kept verbatim from Jira and nodes this package does not produce are trusted, only where nodes of a known
+// type are placed is checked.
+func Validate(document *Node) error {
+	var errs ValidationErrors
+	validateNode(document, "doc", &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validateNode(n *Node, path string, errs *ValidationErrors) {
+	report := func(path, format string, args ...any) {
+		*errs = append(*errs, ValidationError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	spec, known := nodeSpecs[n.Type]
+	if n.verbatim || !known {
+		return
+	}
+
+	if spec.attrs != nil {
+		if message := spec.attrs(n); message != "" {
+			report(path+".attrs", "%s: %s", n.Type, message)
+		}
+	}
+
+	if len(n.Content) < spec.minContent {
+		report(path+".content", "%s must hold at least %d node(s)", n.Type, spec.minContent)
+	}
+	if spec.maxContent > 0 && len(n.Content) > spec.maxContent {
+		report(path+".content", "%s can hold at most %d node(s)", n.Type, spec.maxContent)
+	}
+	for i, child := range n.Content {
+		childPath := fmt.Sprintf("%s.content[%d]", path, i)
+		if child == nil {
+			report(childPath, "missing node")
+			continue
+		}
+		if _, knownChild := nodeSpecs[child.Type]; knownChild && !containsType(spec.content, child.Type) {
+			report(childPath, "%s is not allowed inside of %s", child.Type, n.Type)
+		} else if i == 0 && spec.first != nil && !containsType(spec.first, child.Type) {
+			report(childPath, "%s cannot start with %s", n.Type, child.Type)
+		}
+		if n.Type == NodeTypeCodeBlock && len(child.Marks) > 0 {
+			report(childPath+".marks", "text inside of codeBlock cannot have marks")
+		}
+		validateNode(child, childPath, errs)
+	}
+
+	validateMarks(n, spec, path, report)
+}
+
+func validateMarks(n *Node, spec nodeSpec, path string, report func(path, format string, args ...any)) {
+	seen := map[Mark]bool{}
+	for i, mark := range n.Marks {
+		markPath := fmt.Sprintf("%s.marks[%d]", path, i)
+		if !containsMarkType(spec.marks, mark.Type) {
+			report(markPath, "%s mark is not allowed on %s", mark.Type, n.Type)
+			continue
+		}
+		if seen[mark.Type] {
+			report(markPath, "%s mark is applied twice", mark.Type)
+		}
+		seen[mark.Type] = true
+
+		switch mark.Type {
+		case MarkLink:
+			if mark.Attributes == nil || mark.Attributes.Href == "" {
+				report(markPath, "link mark: href is required")
+			}
+		case MarkAlignment:
+			if mark.Attributes == nil || (mark.Attributes.Align != "center" && mark.Attributes.Align != "end") {
+				report(markPath, "alignment mark: align must be center or end")
+			}
+		}
+	}
+
+	// Code can only be combined with links
+	if seen[MarkCode] {
+		for i, mark := range n.Marks {
+			if mark.Type != MarkCode && mark.Type != MarkLink {
+				report(fmt.Sprintf("%s.marks[%d]", path, i), "%s mark cannot be combined with code", mark.Type)
+			}
+		}
+	}
+}
+
+func containsType(types []NodeType, t NodeType) bool {
+	for _, allowed := range types {
+		if allowed == t {
+			return true
+		}
+	}
+	return false
+}
+
+func containsMarkType(marks []Mark, markType Mark) bool {
+	for _, allowed := range marks {
+		if allowed == markType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package renderer
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestValidateInlineNodeAttributes(t *testing.T) {
+	tests := []struct {
+		name    string
+		inline  string
+		problem string // What Validate reports, empty for a valid node
+	}{
+		{name: "status", inline: `{"type":"status","attrs":{"text":"DONE","color":"green","localId":"a"}}`},
+		{name: "status without text", inline: `{"type":"status","attrs":{"color":"green"}}`, problem: "text is required"},
+		{name: "status without color", inline: `{"type":"status","attrs":{"text":"DONE"}}`, problem: "color is required"},
+		{name: "status without attributes", inline: `{"type":"status"}`, problem: "text is required"},
+		{name: "date", inline: `{"type":"date","attrs":{"timestamp":"1700000000000"}}`},
+		{name: "date without timestamp", inline: `{"type":"date","attrs":{}}`, problem: "timestamp is required"},
+		{name: "date without attributes", inline: `{"type":"date"}`, problem: "timestamp is required"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			doc := new(Node)
+			if err := json.Unmarshal([]byte(paragraphDoc(test.inline)), doc); err != nil {
+				t.Fatal(err)
+			}
+			err := Validate(doc)
+			switch {
+			case test.problem == "" && err != nil:
+				t.Errorf("Validate(%s) = %v, want no error", test.inline, err)
+			case test.problem != "" && (err == nil || !strings.Contains(err.Error(), test.problem)):
+				t.Errorf("Validate(%s) = %v, want %q", test.inline, err, test.problem)
+			}
+		})
+	}
+}
+
+func TestValidateRenderedStatusAndDate(t *testing.T) {
+	doc, err := ToADF([]byte("{status:green|Done} on {date:2024-03-01}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if countNodes(t, doc, NodeTypeStatus) != 1 || countNodes(t, doc, NodeTypeDate) != 1 {
+		raw, _ := json.Marshal(doc)
+		t.Fatalf("Want a status and a date:\n%s", raw)
+	}
+	if err := Validate(doc); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+}
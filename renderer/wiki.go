@@ -0,0 +1,594 @@
+package renderer
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	emojiAst "github.com/yuin/goldmark-emoji/ast"
+	"github.com/yuin/goldmark/ast"
+	extAst "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+)
+
+var _ renderer.Renderer = &WikiRenderer{}
+
+// wikiPanels are the wiki macros that come closest to the ADF panel types
+var wikiPanels = map[PanelType]string{
+	PanelTypeInfo:    "info",
+	PanelTypeNote:    "panel",
+	PanelTypeSuccess: "tip",
+	PanelTypeWarning: "note",
+	PanelTypeError:   "warning",
+}
+
+// WikiRenderer implements goldmark.Renderer for Jira wiki markup, the comment format of the v2 REST API that
+// Jira Server and Data Center use. It parses the same markdown and takes the same options as ADFRenderer:
+// mentions are written with the ID the MentionResolver returns, which must be the username, and local images are
+// uploaded by the MediaUploader and referenced by their file name. Smart links and compact JSON do not apply.
+type WikiRenderer struct {
+	options *ADFRenderer
+}
+
+func NewWikiRenderer(opts ...Option) *WikiRenderer {
+	return &WikiRenderer{options: NewRenderer(opts...)}
+}
+
+// RenderWiki converts markdown into Jira wiki markup and writes it.
+func RenderWiki(w io.Writer, source []byte, opts ...Option) error {
+	return newMarkdown(NewWikiRenderer(opts...)).Convert(source, w)
+}
+
+// ToWiki converts markdown into Jira wiki markup.
+func ToWiki(source []byte, opts ...Option) (string, error) {
+	return NewWikiRenderer(opts...).ToWiki(source)
+}
+
+// ToWiki converts markdown into Jira wiki markup with the options of the renderer.
+func (r *WikiRenderer) ToWiki(source []byte) (string, error) {
+	document := newMarkdown(r).Parser().Parse(text.NewReader(source))
+	return r.convert(source, document)
+}
+
+// Render implements renderer.Renderer.
+func (r *WikiRenderer) Render(w io.Writer, source []byte, n ast.Node) error {
+	markup, err := r.convert(source, n)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, markup)
+	return err
+}
+
+// AddOptions implements renderer.Renderer.
+func (r *WikiRenderer) AddOptions(opts ...renderer.Option) {
+	r.options.AddOptions(opts...)
+}
+
+func (r *WikiRenderer) convert(source []byte, n ast.Node) (string, error) {
+	w := &wikiWriter{
+		renderState: newRenderState(r.options, source),
+		lineBreak:   "\n",
+	}
+	markup, err := w.blocks(n)
+	if err != nil {
+		return "", err
+	}
+	if len(w.errors) > 0 {
+		return "", &UnsupportedError{Unsupported: w.errors}
+	}
+	if err := w.upload(); err != nil {
+		return "", err
+	}
+	return markup, nil
+}
+
+// wikiWriter writes the markdown AST as wiki markup.
+// It shares the render state of ADFRenderer for its options, reporting and mention resolution.
+type wikiWriter struct {
+	*renderState
+	lineBreak string // Newlines end table rows and list items, where line breaks are written as \\ instead
+	inQuote   bool   // Quotes cannot be nested
+	inLink    bool   // Links cannot hold mentions
+}
+
+// blocks writes the block children of n separated by blank lines
+func (w *wikiWriter) blocks(n ast.Node) (string, error) {
+	var out []string
+	for child := n.FirstChild(); child != nil; child = child.NextSibling() {
+		block, err := w.block(child)
+		if err != nil {
+			return "", err
+		}
+		if block != "" {
+			out = append(out, block)
+		}
+	}
+	return strings.Join(out, "\n\n"), nil
+}
+
+func (w *wikiWriter) block(n ast.Node) (string, error) {
+	switch node := n.(type) {
+	case *ast.Paragraph, *ast.TextBlock:
+		return w.inline(n)
+
+	case *ast.Heading:
+		content, err := w.inline(n)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("h%d. %s", node.Level, content), nil
+
+	case *ast.ThematicBreak:
+		return "----", nil
+
+	case *ast.FencedCodeBlock, *ast.CodeBlock:
+		if isPlaceholderBlock(w.source, n) {
+			w.unsupported(n, "adf placeholder", "nothing")
+			return "", nil
+		}
+		code := strings.TrimSuffix(w.lines(n), "\n")
+		if strings.Contains(code, "{code") {
+			// {code} would end the macro early, {noformat} keeps the code without highlighting
+			if strings.Contains(code, "{noformat") {
+				w.unsupported(n, "code block holding both {code} and {noformat}", "a code block cut short")
+			} else {
+				return "{noformat}\n" + code + "\n{noformat}", nil
+			}
+		}
+		macro := "{code}"
+		if fenced, ok := n.(*ast.FencedCodeBlock); ok && len(fenced.Language(w.source)) > 0 {
+			macro = "{code:" + string(fenced.Language(w.source)) + "}"
+		}
+		return macro + "\n" + code + "\n{code}", nil
+
+	case *ast.Blockquote:
+		if w.inQuote {
+			w.unsupported(n, "quote inside of quote", "its content")
+			return w.blocks(n)
+		}
+		w.inQuote = true
+		content, err := w.blocks(n)
+		w.inQuote = false
+		if err != nil {
+			return "", err
+		}
+		return "{quote}\n" + content + "\n{quote}", nil
+
+	case *Admonition:
+		content, err := w.blocks(n)
+		if err != nil {
+			return "", err
+		}
+		macro := wikiPanels[node.PanelType]
+		return "{" + macro + "}\n" + content + "\n{" + macro + "}", nil
+
+	case *Details:
+		w.unsupported(n, "collapsible section", "section with a bold title")
+		content, err := w.blocks(n)
+		if err != nil || node.Title == "" {
+			return content, err
+		}
+		return "*" + w.escape(node.Title) + "*\n\n" + content, nil
+
+	case *ast.List:
+		return w.list(node, "")
+
+	case *extAst.Table:
+		return w.table(node)
+
+	case *ast.HTMLBlock:
+		w.unsupported(n, "HTML", "text")
+		return w.escape(strings.TrimRight(w.lines(n), "\n")), nil
+
+	default:
+		// Keep the content of markdown extensions this renderer does not know about
+		if n.Lines().Len() > 0 {
+			w.unsupported(n, n.Kind().String(), "a paragraph")
+			return w.escape(strings.TrimRight(w.lines(n), "\n")), nil
+		}
+		w.unsupported(n, n.Kind().String(), "its content")
+		return w.blocks(n)
+	}
+}
+
+// lines returns the source lines of a block
+func (w *wikiWriter) lines(n ast.Node) string {
+	var content strings.Builder
+	lines := n.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		segment := lines.At(i)
+		content.Write(segment.Value(w.source))
+	}
+	return content.String()
+}
+
+// list writes every item on its own line, the prefix repeats the markers of the lists it is nested in: ** or #*
+func (w *wikiWriter) list(n *ast.List, prefix string) (string, error) {
+	if n.IsOrdered() {
+		prefix += "#"
+		if n.Start > 1 {
+			w.unsupported(n, "list starting at "+strconv.Itoa(n.Start), "list starting at 1")
+		}
+	} else {
+		prefix += "*"
+	}
+
+	lineBreak := w.lineBreak
+	w.lineBreak = ` \\ `
+	defer func() { w.lineBreak = lineBreak }()
+
+	var lines []string
+	for item := n.FirstChild(); item != nil; item = item.NextSibling() {
+		var text []string
+		var after []string // Nested lists and blocks that cannot be part of the item's line
+		for child := item.FirstChild(); child != nil; child = child.NextSibling() {
+			switch child := child.(type) {
+			case *ast.Paragraph, *ast.TextBlock:
+				content, err := w.inline(child)
+				if err != nil {
+					return "", err
+				}
+				text = append(text, content)
+			case *ast.List:
+				nested, err := w.list(child, prefix)
+				if err != nil {
+					return "", err
+				}
+				after = append(after, nested)
+			default:
+				w.unsupported(child, child.Kind().String()+" inside of list", child.Kind().String()+" after the list")
+				w.lineBreak = "\n"
+				block, err := w.block(child)
+				w.lineBreak = ` \\ `
+				if err != nil {
+					return "", err
+				}
+				after = append(after, block)
+			}
+		}
+		lines = append(lines, strings.TrimRight(prefix+" "+strings.Join(text, w.lineBreak), " "))
+		lines = append(lines, after...)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func (w *wikiWriter) table(n *extAst.Table) (string, error) {
+	lineBreak := w.lineBreak
+	w.lineBreak = ` \\ `
+	defer func() { w.lineBreak = lineBreak }()
+
+	var rows []string
+	for row := n.FirstChild(); row != nil; row = row.NextSibling() {
+		separator := "|"
+		if _, ok := row.(*extAst.TableHeader); ok {
+			separator = "||"
+		}
+		var out strings.Builder
+		out.WriteString(separator)
+		for cell := row.FirstChild(); cell != nil; cell = cell.NextSibling() {
+			content, err := w.inline(cell)
+			if err != nil {
+				return "", err
+			}
+			if content == "" {
+				// Empty cells would merge with their neighbour's separators
+				content = " "
+			}
+			out.WriteString(content + separator)
+		}
+		rows = append(rows, out.String())
+	}
+	return strings.Join(rows, "\n"), nil
+}
+
+// inline writes the inline children of n
+func (w *wikiWriter) inline(n ast.Node) (string, error) {
+	var out strings.Builder
+	if err := w.inlineChildren(&out, n); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+func (w *wikiWriter) inlineChildren(out *strings.Builder, n ast.Node) error {
+	for child := n.FirstChild(); child != nil; child = child.NextSibling() {
+		if err := w.inlineNode(out, child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *wikiWriter) inlineNode(out *strings.Builder, n ast.Node) error {
+	switch node := n.(type) {
+	case *ast.Text:
+		if node.IsRaw() {
+			out.WriteString(string(node.Segment.Value(w.source)))
+		} else {
+			out.WriteString(w.escape(unescapedText(node.Segment.Value(w.source))))
+		}
+		if node.HardLineBreak() {
+			out.WriteString(w.lineBreak)
+		} else if node.SoftLineBreak() {
+			out.WriteString(" ")
+		}
+
+	case *ast.String:
+		out.WriteString(w.escape(string(node.Value)))
+
+	case *ast.CodeSpan:
+		if code := string(n.Text(w.source)); code != "" {
+			out.WriteString("{{" + w.escape(code) + "}}")
+		}
+
+	case *ast.Emphasis:
+		if node.Level == 1 {
+			return w.effect(out, n, "_")
+		}
+		return w.effect(out, n, "*")
+
+	case *extAst.Strikethrough:
+		return w.effect(out, n, "-")
+
+	case *Underline:
+		return w.effect(out, n, "+")
+
+	case *Subsup:
+		if node.SubsupType == SubsupSup {
+			return w.effect(out, n, "^")
+		}
+		return w.effect(out, n, "~")
+
+	case *TextColor:
+		out.WriteString("{color:" + node.Color + "}")
+		if err := w.inlineChildren(out, n); err != nil {
+			return err
+		}
+		out.WriteString("{color}")
+
+	case *ast.Link:
+		var content strings.Builder
+		w.inLink = true
+		err := w.inlineChildren(&content, n)
+		w.inLink = false
+		if err != nil {
+			return err
+		}
+		out.WriteString(wikiLink(strings.TrimSpace(content.String()), string(node.Destination)))
+
+	case *ast.AutoLink:
+		link := string(node.URL(w.source))
+		if node.AutoLinkType == ast.AutoLinkEmail && !strings.HasPrefix(strings.ToLower(link), "mailto:") {
+			out.WriteString(wikiLink(w.escape(link), "mailto:"+link))
+			break
+		}
+		out.WriteString(wikiLink("", link))
+
+	case *ast.Image:
+		return w.image(out, node)
+
+	case *emojiAst.Emoji:
+		if node.Value.IsUnicode() {
+			out.WriteString(string(node.Value.Unicode))
+		} else {
+			out.WriteString(":" + string(node.ShortName) + ":")
+		}
+
+	case *Mention:
+		mention, err := w.mention(w.source, node)
+		if err != nil {
+			return err
+		}
+		switch {
+		case mention.Type != NodeTypeMention:
+			out.WriteString(w.escape(mention.Text))
+		case w.inLink:
+			out.WriteString(w.escape(mention.Attributes.Text))
+		default:
+			out.WriteString("[~" + mention.Attributes.ID + "]")
+		}
+
+	case *Status:
+		w.unsupported(n, "status lozenge", "bold text")
+		w.writeEffect(out, n, "*", w.escape(node.Label))
+
+	case *Date:
+		w.unsupported(n, "date", "text")
+		out.WriteString(node.Date.Format(dateLayout))
+
+	case *extAst.TaskCheckBox:
+		w.unsupported(n.Parent(), "task list item", "check mark")
+		if node.IsChecked {
+			out.WriteString("☑ ")
+		} else {
+			out.WriteString("☐ ")
+		}
+
+	case *ADFPlaceholder:
+		if node.Err != nil {
+			return placeholderError(w.source, node.Offset, node.Err)
+		}
+		w.unsupported(n, "adf placeholder", "nothing")
+
+	case *ast.RawHTML:
+		var content string
+		for i := 0; i < node.Segments.Len(); i++ {
+			segment := node.Segments.At(i)
+			content += string(segment.Value(w.source))
+		}
+		if lineBreakTag.MatchString(content) {
+			out.WriteString(w.lineBreak)
+			break
+		}
+		w.unsupported(n, "HTML", "text")
+		out.WriteString(w.escape(content))
+
+	default:
+		w.unsupported(n, n.Kind().String(), "text")
+		return w.inlineChildren(out, n)
+	}
+	return nil
+}
+
+// effect writes text effects such as *strong* and -strikethrough-
+func (w *wikiWriter) effect(out *strings.Builder, n ast.Node, delimiter string) error {
+	var content strings.Builder
+	if err := w.inlineChildren(&content, n); err != nil {
+		return err
+	}
+	w.writeEffect(out, n, delimiter, content.String())
+	return nil
+}
+
+// writeEffect wraps content in delimiters. Wiki effects only start after and end before spaces and punctuation,
+// next to letters they are written as {*}braced{*} delimiters. Surrounding whitespace is kept outside.
+func (w *wikiWriter) writeEffect(out *strings.Builder, n ast.Node, delimiter, content string) {
+	core := strings.TrimSpace(content)
+	if core == "" {
+		out.WriteString(content)
+		return
+	}
+	lead := content[:strings.Index(content, core)]
+	trail := content[len(lead)+len(core):]
+
+	previous, _ := utf8.DecodeLastRuneInString(out.String())
+	opening, closing := delimiter, delimiter
+	if (lead == "" && isWordRune(previous)) || (trail == "" && w.followedByWord(n)) {
+		opening, closing = "{"+delimiter+"}", "{"+delimiter+"}"
+	}
+	out.WriteString(lead + opening + core + closing + trail)
+}
+
+// followedByWord reports whether the text after n starts with a letter or digit
+func (w *wikiWriter) followedByWord(n ast.Node) bool {
+	next, ok := n.NextSibling().(*ast.Text)
+	if !ok {
+		return false
+	}
+	r, _ := utf8.DecodeRune(next.Segment.Value(w.source))
+	return isWordRune(r)
+}
+
+func (w *wikiWriter) image(out *strings.Builder, n *ast.Image) error {
+	destination := string(n.Destination)
+	if link, err := url.Parse(destination); err == nil && (link.Scheme == "http" || link.Scheme == "https") {
+		out.WriteString("!" + destination + "!")
+		return nil
+	}
+
+	path := localPath(destination)
+	if name := siteFile(destination); path == "" && name != "" {
+		// Images already on the site, like the attachments of a comment being edited, are referenced as they are
+		out.WriteString("!" + name + "!")
+		return nil
+	}
+	if w.media == nil || path == "" {
+		w.unsupported(n, "image "+destination, "text")
+		if alt := altText(w.source, n); alt != "" {
+			out.WriteString(w.escape(alt))
+		} else {
+			out.WriteString(w.escape(destination))
+		}
+		return nil
+	}
+	// Attached images are referenced by their file name, so two files cannot share one
+	name := filepath.Base(path)
+	for _, u := range w.uploads {
+		if u.path != path && filepath.Base(u.path) == name {
+			return fmt.Errorf("images %s and %s would both be attached as %s, rename one of them",
+				u.destination, destination, name)
+		}
+	}
+	w.uploads = append(w.uploads, upload{path: path, destination: destination})
+	out.WriteString("!" + name + "!")
+	return nil
+}
+
+// siteFile returns the file name of an image on the Jira site, linked by a path like /secure/attachment/10000/chart.png
+func siteFile(destination string) string {
+	link, err := url.Parse(destination)
+	if err != nil || link.Scheme != "" || link.Host != "" || !strings.HasPrefix(link.Path, "/") {
+		return ""
+	}
+	name := path.Base(link.Path)
+	if name == "/" {
+		return ""
+	}
+	return name
+}
+
+// wikiLinkDestination percent-encodes the characters that would end a link early
+var wikiLinkDestination = strings.NewReplacer("|", "%7C", "[", "%5B", "]", "%5D")
+
+// wikiLink writes a link with content that is wiki markup already. Images in it are written unescaped, so any | or ]
+// left would still end the link early.
+func wikiLink(content, destination string) string {
+	if content == "" || content == destination {
+		return "[" + wikiLinkDestination.Replace(destination) + "]"
+	}
+
+	var escaped strings.Builder
+	runes := []rune(content)
+	for i := 0; i < len(runes); i++ {
+		switch runes[i] {
+		case '\\':
+			escaped.WriteRune(runes[i])
+			if i+1 < len(runes) {
+				i++
+				escaped.WriteRune(runes[i])
+			}
+			continue
+		case '|', '[', ']':
+			escaped.WriteRune('\\')
+		}
+		escaped.WriteRune(runes[i])
+	}
+	return "[" + escaped.String() + "|" + wikiLinkDestination.Replace(destination) + "]"
+}
+
+// escape backslash escapes characters that would otherwise be read as wiki markup
+func (w *wikiWriter) escape(text string) string {
+	var out strings.Builder
+	runes := []rune(text)
+	for i, r := range runes {
+		previous, next := ' ', ' '
+		if i > 0 {
+			previous = runes[i-1]
+		}
+		if i < len(runes)-1 {
+			next = runes[i+1]
+		}
+
+		switch r {
+		case '\\', '{', '}', '[', ']', '|', '!':
+			out.WriteRune('\\')
+		case '*', '_', '-', '+', '^', '~':
+			// Effects open before and close after a non-space character, and never in the middle of a word
+			opens := !isWordRune(previous) && !unicode.IsSpace(next)
+			closes := !unicode.IsSpace(previous) && !isWordRune(next)
+			if i == 0 || opens || closes {
+				out.WriteRune('\\')
+			}
+		case '?':
+			// ??citations??
+			if previous == '?' || next == '?' {
+				out.WriteRune('\\')
+			}
+		case '#':
+			// Keep "# text" at the start of a line from becoming an ordered list
+			if i == 0 {
+				out.WriteRune('\\')
+			}
+		}
+		out.WriteRune(r)
+	}
+	return out.String()
+}
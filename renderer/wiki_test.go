@@ -0,0 +1,98 @@
+package renderer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWikiLinks(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		wiki     string
+	}{
+		{name: "plain", markdown: "[docs](https://example.com/docs)", wiki: "[docs|https://example.com/docs]"},
+		{name: "bare", markdown: "<https://example.com/docs>", wiki: "[https://example.com/docs]"},
+		{name: "pipe in text", markdown: "[a | b](https://example.com)", wiki: `[a \| b|https://example.com]`},
+		{name: "pipe in code", markdown: "[`a|b`](https://example.com)", wiki: `[{{a\|b}}|https://example.com]`},
+		{name: "bracket in text", markdown: `[a \] b](https://example.com)`, wiki: `[a \] b|https://example.com]`},
+		{name: "pipe in destination", markdown: "[search](https://example.com/?q=a|b)",
+			wiki: "[search|https://example.com/?q=a%7Cb]"},
+		{name: "brackets in destination", markdown: "[list](<https://example.com/?ids[]=1>)",
+			wiki: "[list|https://example.com/?ids%5B%5D=1]"},
+		{name: "image in text", markdown: "[![logo](https://example.com/a|b.png)](https://example.com)",
+			wiki: `[!https://example.com/a\|b.png!|https://example.com]`},
+		{name: "mention in text", markdown: "[ask @jane.doe](https://example.com)",
+			wiki: "[ask @jane.doe|https://example.com]"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			markup, err := ToWiki([]byte(test.markdown), WithMentionResolver(team))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if markup != test.wiki {
+				t.Errorf("ToWiki(%q) = %q, want %q", test.markdown, markup, test.wiki)
+			}
+		})
+	}
+}
+
+func TestWikiCodeBlocks(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		wiki     string
+		warning  bool
+	}{
+		{name: "code", markdown: "```go\nfmt.Println()\n```", wiki: "{code:go}\nfmt.Println()\n{code}"},
+		{name: "code macro inside", markdown: "```\nwrite {code} to end it\n```",
+			wiki: "{noformat}\nwrite {code} to end it\n{noformat}"},
+		{name: "code macro with language inside", markdown: "```md\n{code:java}\nx\n{code}\n```",
+			wiki: "{noformat}\n{code:java}\nx\n{code}\n{noformat}"},
+		{name: "both macros inside", markdown: "```\n{code} and {noformat}\n```",
+			wiki: "{code}\n{code} and {noformat}\n{code}", warning: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var warnings []Unsupported
+			markup, err := ToWiki([]byte(test.markdown), WithWarnings(func(u Unsupported) {
+				warnings = append(warnings, u)
+			}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if markup != test.wiki {
+				t.Errorf("ToWiki(%q) = %q, want %q", test.markdown, markup, test.wiki)
+			}
+			if warned := len(warnings) > 0; warned != test.warning {
+				t.Errorf("ToWiki(%q) warned %v, want a warning %v", test.markdown, warnings, test.warning)
+			}
+		})
+	}
+}
+
+func TestWikiImagesWithTheSameName(t *testing.T) {
+	first, second := writeImage(t, "chart.png"), writeImage(t, "chart.png")
+	uploader := &recordingUploader{}
+
+	markdown := "![before](" + first + ")\n\n![again](" + first + ")\n\n![after](" + second + ")"
+	_, err := ToWiki([]byte(markdown), WithMediaUploader(uploader))
+	if err == nil || !strings.Contains(err.Error(), "both be attached as chart.png") {
+		t.Errorf("ToWiki returned %v, want an error about the two charts", err)
+	}
+	if len(uploader.paths) > 0 {
+		t.Errorf("ToWiki uploaded %q for a rejected document", uploader.paths)
+	}
+
+	// The same file twice is attached once
+	markup, err := ToWiki([]byte("![before]("+first+")\n\n![again]("+first+")"), WithMediaUploader(uploader))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if markup != "!chart.png!\n\n!chart.png!" || len(uploader.paths) != 1 {
+		t.Errorf("ToWiki wrote %q and uploaded %q, want chart.png attached once", markup, uploader.paths)
+	}
+}